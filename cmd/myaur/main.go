@@ -4,11 +4,16 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"maps"
 	"os"
+	"slices"
+	"strings"
 	"time"
 
+	"github.com/haileyok/myaur/myaur/database"
 	"github.com/haileyok/myaur/myaur/gitrepo"
 	"github.com/haileyok/myaur/myaur/populate"
+	"github.com/haileyok/myaur/myaur/resolve"
 	"github.com/haileyok/myaur/myaur/server"
 	_ "github.com/joho/godotenv/autoload"
 	"github.com/urfave/cli/v2"
@@ -37,25 +42,41 @@ func main() {
 						Usage: "remote aur repo url",
 						Value: gitrepo.DefaultAurRepoUrl,
 					},
+					&cli.StringFlag{
+						Name:  "git-backend",
+						Usage: "git implementation to use, either exec (the git binary) or go-git (in process, no git binary needed)",
+						Value: gitrepo.BackendExec,
+					},
 					&cli.BoolFlag{
 						Name:  "debug",
 						Usage: "flag to enable debug logs",
 					},
+					&cli.StringFlag{
+						Name:  "maintainer-overrides",
+						Usage: "path to a file mapping commit author emails to aur usernames, one email = username per line",
+					},
 					&cli.IntFlag{
 						Name:  "concurrency",
 						Usage: "worker concurrency for parsing and adding packages to database",
 						Value: 10,
 					},
+					&cli.BoolFlag{
+						Name:  "full",
+						Usage: "reprocess every branch, even ones that have not changed since the last populate",
+					},
 				},
 				Action: func(cmd *cli.Context) error {
 					ctx := context.Background()
 
 					p, err := populate.New(&populate.Args{
-						DatabasePath:  cmd.String("database-path"),
-						RepoPath:      cmd.String("repo-path"),
-						RemoteRepoUrl: cmd.String("remote-repo-url"),
-						Debug:         cmd.Bool("debug"),
-						Concurrency:   cmd.Int("concurrency"),
+						DatabasePath:        cmd.String("database-path"),
+						RepoPath:            cmd.String("repo-path"),
+						RemoteRepoUrl:       cmd.String("remote-repo-url"),
+						GitBackend:          cmd.String("git-backend"),
+						Debug:               cmd.Bool("debug"),
+						Concurrency:         cmd.Int("concurrency"),
+						MaintainerOverrides: cmd.String("maintainer-overrides"),
+						Full:                cmd.Bool("full"),
 					})
 					if err != nil {
 						return fmt.Errorf("failed to create populate client: %w", err)
@@ -68,6 +89,132 @@ func main() {
 					return nil
 				},
 			},
+			&cli.Command{
+				Name:      "resolve",
+				Usage:     "print the aur packages that need to be built for the given packages, in build order",
+				ArgsUsage: "<package> [package...]",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "database-path",
+						Usage: "path to database file",
+						Value: "./myaur.db",
+					},
+					&cli.StringFlag{
+						Name:  "arch",
+						Usage: "also follow the architecture specific dependencies for this arch",
+					},
+					&cli.BoolFlag{
+						Name:  "follow-provides",
+						Usage: "build an aur package that provides a dependency when no aur package has its name",
+					},
+					&cli.BoolFlag{
+						Name:  "debug",
+						Usage: "flag to enable debug logs",
+					},
+				},
+				Action: func(cmd *cli.Context) error {
+					if cmd.NArg() == 0 {
+						return fmt.Errorf("must supply at least one package")
+					}
+
+					db, err := database.New(&database.Args{
+						DatabasePath: cmd.String("database-path"),
+						Debug:        cmd.Bool("debug"),
+					})
+					if err != nil {
+						return fmt.Errorf("failed to create database client: %w", err)
+					}
+
+					result, err := resolve.New(db).Resolve(cmd.Args().Slice(), resolve.Options{
+						Arch:           cmd.String("arch"),
+						FollowProvides: cmd.Bool("follow-provides"),
+					})
+					if err != nil {
+						return fmt.Errorf("failed to resolve packages: %w", err)
+					}
+
+					for i, build := range result.Order {
+						fmt.Printf("%d. %s (%s)\n", i+1, build.PackageBase, strings.Join(build.Packages, ", "))
+					}
+
+					if len(result.Missing) > 0 {
+						fmt.Printf("\nnot found in the aur: %s\n", strings.Join(result.Missing, ", "))
+					}
+
+					for _, name := range slices.Sorted(maps.Keys(result.Providers)) {
+						fmt.Printf("\nnot found in the aur, but provided by: %s (%s)\n", name, strings.Join(result.Providers[name], ", "))
+					}
+
+					if len(result.Unsatisfied) > 0 {
+						fmt.Printf("\nversion constraints not satisfied by the aur: %s\n", strings.Join(result.Unsatisfied, ", "))
+					}
+
+					for _, cycle := range result.Cycles {
+						fmt.Printf("\ndependency cycle: %s\n", strings.Join(cycle, " -> "))
+					}
+
+					return nil
+				},
+			},
+			&cli.Command{
+				Name:      "reverse-deps",
+				Usage:     "print the packages that depend on the given package",
+				ArgsUsage: "<package>",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "database-path",
+						Usage: "path to database file",
+						Value: "./myaur.db",
+					},
+					&cli.BoolFlag{
+						Name:  "transitive",
+						Usage: "also include packages that depend on the package through other packages",
+					},
+					&cli.BoolFlag{
+						Name:  "debug",
+						Usage: "flag to enable debug logs",
+					},
+				},
+				Action: func(cmd *cli.Context) error {
+					if cmd.NArg() != 1 {
+						return fmt.Errorf("must supply a single package")
+					}
+
+					db, err := database.New(&database.Args{
+						DatabasePath: cmd.String("database-path"),
+						Debug:        cmd.Bool("debug"),
+					})
+					if err != nil {
+						return fmt.Errorf("failed to create database client: %w", err)
+					}
+
+					result, err := resolve.New(db).ReverseDeps(cmd.Args().First(), cmd.Bool("transitive"))
+					if err != nil {
+						return fmt.Errorf("failed to lookup reverse dependencies: %w", err)
+					}
+
+					for _, kind := range []struct {
+						name string
+						deps []resolve.Dependent
+					}{
+						{"depends", result.Depends},
+						{"makedepends", result.MakeDepends},
+						{"optdepends", result.OptDepends},
+						{"checkdepends", result.CheckDepends},
+					} {
+						fmt.Printf("%s (%d):\n", kind.name, len(kind.deps))
+						for _, dep := range kind.deps {
+							if dep.Depth == 1 {
+								fmt.Printf("  %s\n", dep.Name)
+							} else {
+								fmt.Printf("  %s (via %s, depth %d)\n", dep.Name, dep.Via, dep.Depth)
+							}
+						}
+					}
+
+					return nil
+				},
+			},
 			&cli.Command{
 				Name: "serve",
 				Flags: []cli.Flag{
@@ -91,6 +238,11 @@ func main() {
 						Usage: "remote aur repo url",
 						Value: gitrepo.DefaultAurRepoUrl,
 					},
+					&cli.StringFlag{
+						Name:  "git-backend",
+						Usage: "git implementation to use, either exec (the git binary) or go-git (in process, no git binary needed)",
+						Value: gitrepo.BackendExec,
+					},
 					&cli.StringFlag{
 						Name:  "repo-path",
 						Usage: "path to store/update the AUR git mirror",
@@ -100,11 +252,25 @@ func main() {
 						Name:  "debug",
 						Usage: "flag to enable debug logs",
 					},
+					&cli.StringFlag{
+						Name:  "maintainer-overrides",
+						Usage: "path to a file mapping commit author emails to aur usernames, one email = username per line",
+					},
 					&cli.IntFlag{
 						Name:  "concurrency",
 						Usage: "worker concurrency for parsing and adding packages to database",
 						Value: 10,
 					},
+					&cli.IntFlag{
+						Name:  "max-results",
+						Usage: "the most packages a single search can return",
+						Value: 5000,
+					},
+					&cli.DurationFlag{
+						Name:  "git-timeout",
+						Usage: "how long a single clone or fetch from the mirror may take before it gets cancelled",
+						Value: 10 * time.Minute,
+					},
 					&cli.BoolFlag{
 						Name:  "auto-update",
 						Usage: "automatically pull updates from the remote repo at the set interval",
@@ -120,14 +286,18 @@ func main() {
 					ctx := context.Background()
 
 					s, err := server.New(&server.Args{
-						Addr:           cmd.String("listen-addr"),
-						DatabasePath:   cmd.String("database-path"),
-						RemoteRepoUrl:  cmd.String("remote-repo-url"),
-						RepoPath:       cmd.String("repo-path"),
-						Concurrency:    cmd.Int("concurrency"),
-						AutoUpdate:     cmd.Bool("auto-update"),
-						UpdateInterval: cmd.Duration("update-interval"),
-						Debug:          cmd.Bool("debug"),
+						Addr:                cmd.String("listen-addr"),
+						DatabasePath:        cmd.String("database-path"),
+						RemoteRepoUrl:       cmd.String("remote-repo-url"),
+						RepoPath:            cmd.String("repo-path"),
+						GitBackend:          cmd.String("git-backend"),
+						Concurrency:         cmd.Int("concurrency"),
+						MaintainerOverrides: cmd.String("maintainer-overrides"),
+						MaxResults:          cmd.Int("max-results"),
+						GitTimeout:          cmd.Duration("git-timeout"),
+						AutoUpdate:          cmd.Bool("auto-update"),
+						UpdateInterval:      cmd.Duration("update-interval"),
+						Debug:               cmd.Bool("debug"),
 					})
 					if err != nil {
 						return fmt.Errorf("failed to create new myaur server: %w", err)
@@ -0,0 +1,182 @@
+package database
+
+import (
+	"slices"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// PackageUpdate is a single package parsed out of a branch, along with its normalized relations
+type PackageUpdate struct {
+	Package   PackageInfo
+	Relations []PackageRelation
+}
+
+// BranchUpdate is everything parsed out of a single branch at a given commit
+type BranchUpdate struct {
+	Branch   string
+	Commit   string
+	Packages []PackageUpdate
+}
+
+// upsertPackages inserts or updates every package as part of tx
+func upsertPackages(tx *gorm.DB, pkgs []PackageInfo) error {
+	if len(pkgs) == 0 {
+		return nil
+	}
+
+	// on conflict, every column other than the id gets replaced with the new values
+	return tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "name"}},
+		UpdateAll: true,
+	}).CreateInBatches(pkgs, 100).Error
+}
+
+// ApplyBranchUpdates stores every package and relation from the given branches in a single transaction, removes
+// any packages that a branch no longer builds, and records the commit each branch was processed at. the number of
+// packages that were removed is returned
+func (db *Database) ApplyBranchUpdates(updates []BranchUpdate) (int64, error) {
+	var removed int64
+	err := db.db.Transaction(func(tx *gorm.DB) error {
+		var bases []string
+		for _, u := range updates {
+			for _, pu := range u.Packages {
+				bases = append(bases, pu.Package.PackageBase)
+			}
+		}
+
+		baseIds, err := packageBaseIds(tx, bases)
+		if err != nil {
+			return err
+		}
+
+		var pkgs []PackageInfo
+		var names []string
+		for _, u := range updates {
+			for _, pu := range u.Packages {
+				pu.Package.Id = 0
+				pu.Package.PackageBaseID = baseIds[pu.Package.PackageBase]
+				pkgs = append(pkgs, pu.Package)
+				names = append(names, pu.Package.Name)
+			}
+		}
+
+		if err := upsertPackages(tx, pkgs); err != nil {
+			return err
+		}
+
+		// sqlite doesn't guarantee the order of ids returned from a multi row upsert, so look them up by name
+		ids := make(map[string]int64, len(names))
+		for chunk := range slices.Chunk(names, 500) {
+			var rows []PackageInfo
+			if err := tx.Select("id", "name").Where("name IN ?", chunk).Find(&rows).Error; err != nil {
+				return err
+			}
+			for _, row := range rows {
+				ids[row.Name] = row.Id
+			}
+		}
+
+		var relations []PackageRelation
+		for _, u := range updates {
+			var branchNames []string
+			for _, pu := range u.Packages {
+				branchNames = append(branchNames, pu.Package.Name)
+				for _, rel := range pu.Relations {
+					rel.Id = 0
+					rel.PackageId = ids[pu.Package.Name]
+					relations = append(relations, rel)
+				}
+			}
+
+			// split packages can stop building one of their packages, in which case it needs to go away
+			if len(u.Packages) > 0 {
+				n, err := deleteRemovedSplitPackages(tx, u.Packages[0].Package.PackageBase, branchNames)
+				if err != nil {
+					return err
+				}
+				removed += n
+			}
+		}
+
+		idList := make([]int64, 0, len(ids))
+		for _, id := range ids {
+			idList = append(idList, id)
+		}
+		for chunk := range slices.Chunk(idList, 500) {
+			if err := tx.Where("package_id IN ?", chunk).Delete(&PackageRelation{}).Error; err != nil {
+				return err
+			}
+		}
+
+		if len(relations) > 0 {
+			if err := tx.CreateInBatches(relations, 500).Error; err != nil {
+				return err
+			}
+		}
+
+		branches := make([]Branch, 0, len(updates))
+		for _, u := range updates {
+			branches = append(branches, Branch{Name: u.Branch, Commit: u.Commit})
+		}
+		if len(branches) > 0 {
+			if err := tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "name"}},
+				DoUpdates: clause.AssignmentColumns([]string{"commit"}),
+			}).CreateInBatches(branches, 500).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	return removed, err
+}
+
+// packageBaseIds returns the id of every given package base, creating ids for any that don't have one yet
+func packageBaseIds(tx *gorm.DB, bases []string) (map[string]int64, error) {
+	slices.Sort(bases)
+	bases = slices.Compact(bases)
+
+	rows := make([]PackageBase, 0, len(bases))
+	for _, base := range bases {
+		rows = append(rows, PackageBase{Name: base})
+	}
+	if len(rows) > 0 {
+		if err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "name"}},
+			DoNothing: true,
+		}).CreateInBatches(rows, 500).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	// bases that already existed don't get their id filled in by the insert, so look all of them up
+	ids := make(map[string]int64, len(bases))
+	for chunk := range slices.Chunk(bases, 500) {
+		var found []PackageBase
+		if err := tx.Where("name IN ?", chunk).Find(&found).Error; err != nil {
+			return nil, err
+		}
+		for _, b := range found {
+			ids[b.Name] = b.Id
+		}
+	}
+
+	return ids, nil
+}
+
+func deleteRemovedSplitPackages(tx *gorm.DB, base string, names []string) (int64, error) {
+	ids := tx.Model(&PackageInfo{}).Select("id").Where("package_base = ? AND name NOT IN ?", base, names)
+	if err := tx.Where("package_id IN (?)", ids).Delete(&PackageRelation{}).Error; err != nil {
+		return 0, err
+	}
+
+	result := tx.Where("package_base = ? AND name NOT IN ?", base, names).Delete(&PackageInfo{})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+
+	return result.RowsAffected, nil
+}
@@ -1,17 +1,36 @@
 package database
 
 import (
+	"database/sql"
 	"fmt"
 	"log/slog"
 	"os"
+	"slices"
+	"strings"
 
+	"github.com/haileyok/myaur/myaur/vercmp"
+	"github.com/mattn/go-sqlite3"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
+// the name of the sqlite driver, registered with a `vercmp` collation so that packages can be ordered by version
+// inside of sqlite, rather than having to load every result to sort them
+const driverName = "sqlite3_myaur"
+
+func init() {
+	sql.Register(driverName, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterCollation("vercmp", vercmp.Compare)
+		},
+	})
+}
+
 type Database struct {
 	logger *slog.Logger
 	db     *gorm.DB
+	// whether the fts5 search index is available
+	fts bool
 }
 
 type Args struct {
@@ -31,38 +50,141 @@ func New(args *Args) (*Database, error) {
 
 	logger = logger.With("component", "database")
 
-	gormDb, err := gorm.Open(sqlite.Open(args.DatabasePath))
+	// populate writes from a single goroutine, but the server reads while it does so. wal lets those reads happen
+	// alongside the writes, and the busy timeout covers the rest rather than failing with `database is locked`
+	dsn := args.DatabasePath
+	if !strings.Contains(dsn, "?") {
+		dsn += "?_journal_mode=WAL&_busy_timeout=5000"
+	}
+
+	gormDb, err := gorm.Open(sqlite.New(sqlite.Config{
+		DriverName: driverName,
+		DSN:        dsn,
+	}))
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
 	if err := gormDb.AutoMigrate(
 		&PackageInfo{},
+		&PackageRelation{},
+		&Branch{},
+		&PackageBase{},
 	); err != nil {
 		return nil, fmt.Errorf("failed to migrate db: %w", err)
 	}
 
+	// suggestions ignore case, so they range scan over indexes of the lowercased names. the names themselves are in
+	// the index too, so the results come out of it already in order and the scan stops once it has enough of them
+	for _, column := range []string{"name", "package_base"} {
+		if err := gormDb.Exec(fmt.Sprintf("DROP INDEX IF EXISTS idx_package_info_lower_%s", column)).Error; err != nil {
+			return nil, fmt.Errorf("failed to drop old suggestion index: %w", err)
+		}
+		if err := gormDb.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_package_info_suggest_%s ON package_info(lower(%s), %s)", column, column, column)).Error; err != nil {
+			return nil, fmt.Errorf("failed to create suggestion index: %w", err)
+		}
+	}
+
+	fts, err := setupSearchIndex(gormDb, logger)
+	if err != nil {
+		return nil, err
+	}
+
 	db := Database{
 		logger: logger,
 		db:     gormDb,
+		fts:    fts,
 	}
 
 	return &db, nil
 }
 
-func (db *Database) UpsertPackage(pkg *PackageInfo) error {
-	result := db.db.Where("name = ?", pkg.Name).FirstOrCreate(pkg)
-	if result.Error != nil {
-		return result.Error
+// Transaction runs fn inside of a single transaction, passing it a Database that uses the transaction. nothing fn
+// writes is visible to other readers until it returns, and everything is rolled back if it returns an error. any
+// transactions started on the Database passed to fn become savepoints inside of this one.
+func (db *Database) Transaction(fn func(tx *Database) error) error {
+	return db.db.Transaction(func(tx *gorm.DB) error {
+		return fn(&Database{
+			logger: db.logger,
+			db:     tx,
+			fts:    db.fts,
+		})
+	})
+}
+
+// GetBranchCommits returns the last processed commit for every branch
+func (db *Database) GetBranchCommits() (map[string]string, error) {
+	var branches []Branch
+	if err := db.db.Find(&branches).Error; err != nil {
+		return nil, err
 	}
 
-	if result.RowsAffected == 0 {
-		if err := db.db.Model(pkg).Where("name = ?", pkg.Name).Updates(pkg).Error; err != nil {
-			return err
-		}
+	commits := make(map[string]string, len(branches))
+	for _, b := range branches {
+		commits[b.Name] = b.Commit
+	}
+	return commits, nil
+}
+
+// GetPackageBases returns every distinct package base in the database
+func (db *Database) GetPackageBases() ([]string, error) {
+	var bases []string
+	if err := db.db.Model(&PackageInfo{}).Distinct("package_base").Pluck("package_base", &bases).Error; err != nil {
+		return nil, err
+	}
+	return bases, nil
+}
+
+// Submission is when a package base was first submitted, and who submitted it
+type Submission struct {
+	FirstSubmitted int64
+	Submitter      string
+}
+
+// GetSubmissions returns the submission of every package base that has one
+func (db *Database) GetSubmissions() (map[string]Submission, error) {
+	var rows []struct {
+		PackageBase    string
+		FirstSubmitted int64
+		Submitter      string
+	}
+	if err := db.db.Model(&PackageInfo{}).Distinct("package_base", "first_submitted", "submitter").
+		Where("first_submitted != 0").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	submissions := make(map[string]Submission, len(rows))
+	for _, row := range rows {
+		submissions[row.PackageBase] = Submission{FirstSubmitted: row.FirstSubmitted, Submitter: row.Submitter}
 	}
+	return submissions, nil
+}
+
+// DeletePackageBases removes every package in the given package bases, along with their relations and the record
+// of their branch. the number of packages that were removed is returned
+func (db *Database) DeletePackageBases(bases []string) (int64, error) {
+	var removed int64
+	err := db.db.Transaction(func(tx *gorm.DB) error {
+		// chunk these up so we don't run into sqlite's limit on the number of variables in a query
+		for chunk := range slices.Chunk(bases, 500) {
+			ids := tx.Model(&PackageInfo{}).Select("id").Where("package_base IN ?", chunk)
+			if err := tx.Where("package_id IN (?)", ids).Delete(&PackageRelation{}).Error; err != nil {
+				return err
+			}
 
-	return nil
+			result := tx.Where("package_base IN ?", chunk).Delete(&PackageInfo{})
+			if result.Error != nil {
+				return result.Error
+			}
+			removed += result.RowsAffected
+
+			if err := tx.Where("name IN ?", chunk).Delete(&Branch{}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return removed, err
 }
 
 func (db *Database) GetPackageByName(name string) (*PackageInfo, error) {
@@ -73,36 +195,144 @@ func (db *Database) GetPackageByName(name string) (*PackageInfo, error) {
 	return &pkg, nil
 }
 
-func (db *Database) GetPackagesByName(name string) ([]PackageInfo, error) {
-	var pkgs []PackageInfo
-	searchTerm := "%" + name + "%"
-	if err := db.db.Where("name LIKE ?", searchTerm).Find(&pkgs).Error; err != nil {
+func (db *Database) GetPackageByDescriptionOrName(query string) (*PackageInfo, error) {
+	var pkg PackageInfo
+	if err := db.db.Where("name = ? OR description = ?", query, query).First(&pkg).Error; err != nil {
 		return nil, err
 	}
+	return &pkg, nil
+}
+
+func (db *Database) GetPackagesByNames(names []string) ([]PackageInfo, error) {
+	// the aur returns an empty list rather than null when none of the packages exist
+	pkgs := []PackageInfo{}
+	// info requests can be POSTed with any number of names, so these get chunked up to stay under sqlite's limit on
+	// the number of variables in a query
+	for chunk := range slices.Chunk(names, 500) {
+		var found []PackageInfo
+		if err := db.db.Where("name IN ?", chunk).Find(&found).Error; err != nil {
+			return nil, err
+		}
+		pkgs = append(pkgs, found...)
+	}
 	return pkgs, nil
 }
 
-func (db *Database) GetPackageByDescriptionOrName(query string) (*PackageInfo, error) {
-	var pkg PackageInfo
-	if err := db.db.Where("name = ? OR description = ?", query, query).First(&pkg).Error; err != nil {
+// SuggestPackageNames returns up to limit package names that start with the given prefix, ignoring case, in
+// alphabetical order
+func (db *Database) SuggestPackageNames(prefix string, limit int) ([]string, error) {
+	return db.suggest("name", prefix, limit)
+}
+
+// SuggestPackageBases returns up to limit package base names that start with the given prefix, ignoring case, in
+// alphabetical order
+func (db *Database) SuggestPackageBases(prefix string, limit int) ([]string, error) {
+	return db.suggest("package_base", prefix, limit)
+}
+
+// suggest looks up values of the column starting with prefix, ignoring case like the aur does. an empty prefix
+// matches nothing. these get requested on every keystroke, so rather than a LIKE (which can't use an index) it does a
+// range scan over the index of the lowercased column
+func (db *Database) suggest(column, prefix string, limit int) ([]string, error) {
+	names := []string{}
+	if prefix == "" {
+		return names, nil
+	}
+
+	lower := fmt.Sprintf("lower(%s)", column)
+	prefix = strings.ToLower(prefix)
+
+	tx := db.db.Model(&PackageInfo{}).Where(lower+" >= ?", prefix)
+	if end, ok := prefixEnd(prefix); ok {
+		tx = tx.Where(lower+" < ?", end)
+	}
+
+	// grouping by the index's columns rather than using distinct lets sqlite read the names straight out of the
+	// index, without building a temp b-tree over the whole prefix range
+	key := lower + ", " + column
+	if err := tx.Group(key).Order(key).Limit(limit).Pluck(column, &names).Error; err != nil {
 		return nil, err
 	}
-	return &pkg, nil
+	return names, nil
 }
 
-func (db *Database) GetPackagesByDescriptionOrName(query string) ([]PackageInfo, error) {
+// prefixEnd returns the smallest string that is greater than every string starting with prefix. ok is false if there
+// is no such string, i.e. the prefix is all 0xff bytes
+func prefixEnd(prefix string) (string, bool) {
+	b := []byte(prefix)
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] < 0xff {
+			b[i]++
+			return string(b[:i+1]), true
+		}
+	}
+	return "", false
+}
+
+// columns holding json lists of plain values, where a search needs to match one of the values exactly
+var listColumns = map[string]struct{}{
+	"co_maintainers": {},
+	"keywords":       {},
+	"groups":         {},
+}
+
+// listValueFilter matches packages where the given list column contains the value
+func (db *Database) listValueFilter(column, value string) (*gorm.DB, error) {
+	if _, ok := listColumns[column]; !ok {
+		return nil, fmt.Errorf("invalid list column %s", column)
+	}
+
+	query := fmt.Sprintf("EXISTS (SELECT 1 FROM json_each(package_info.%s) WHERE json_each.value = ?)", column)
+	return db.db.Model(&PackageInfo{}).Where(query, value), nil
+}
+
+// relationFilter matches packages that have a relation of the given kind on the given package name
+func (db *Database) relationFilter(kind, name string) *gorm.DB {
+	sub := db.db.Model(&PackageRelation{}).Select("package_id").Where("name = ? AND kind = ?", name, kind)
+	return db.db.Model(&PackageInfo{}).Where("package_info.id IN (?)", sub)
+}
+
+// GetPackagesByRelation returns every package that has a relation of the given kind (`depends`, `makedepends`,
+// `optdepends`, `checkdepends`, `provides`, `conflicts` or `replaces`) on the given package name, ignoring any
+// version constraint on the relation
+func (db *Database) GetPackagesByRelation(kind, name string) ([]PackageInfo, error) {
 	var pkgs []PackageInfo
-	searchTerm := "%" + query + "%"
-	if err := db.db.Where("name LIKE ? OR description LIKE ?", searchTerm, searchTerm).Find(&pkgs).Error; err != nil {
+	if err := db.relationFilter(kind, name).Find(&pkgs).Error; err != nil {
 		return nil, err
 	}
 	return pkgs, nil
 }
 
-func (db *Database) GetPackagesByNames(names []string) ([]PackageInfo, error) {
-	var pkgs []PackageInfo
-	if err := db.db.Where("name IN ?", names).Find(&pkgs).Error; err != nil {
+// RelationRow is a relation along with the name of the package that it belongs to
+type RelationRow struct {
+	PackageName string
+	Kind        string
+	Name        string
+}
+
+// GetReverseRelations returns every relation of one of the given kinds that points at one of the given names,
+// i.e. every package that depends on one of them
+func (db *Database) GetReverseRelations(names []string, kinds []string) ([]RelationRow, error) {
+	var rows []RelationRow
+	if err := db.db.Model(&PackageRelation{}).
+		Select("package_info.name AS package_name, package_relations.kind, package_relations.name").
+		Joins("JOIN package_info ON package_info.id = package_relations.package_id").
+		Where("package_relations.name IN ? AND package_relations.kind IN ?", names, kinds).
+		Scan(&rows).Error; err != nil {
 		return nil, err
 	}
-	return pkgs, nil
+	return rows, nil
+}
+
+// GetPackageRelations returns every relation of one of the given kinds that belongs to one of the given packages
+func (db *Database) GetPackageRelations(packageNames []string, kinds []string) ([]RelationRow, error) {
+	var rows []RelationRow
+	if err := db.db.Model(&PackageRelation{}).
+		Select("package_info.name AS package_name, package_relations.kind, package_relations.name").
+		Joins("JOIN package_info ON package_info.id = package_relations.package_id").
+		Where("package_info.name IN ? AND package_relations.kind IN ?", packageNames, kinds).
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	return rows, nil
 }
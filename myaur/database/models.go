@@ -34,28 +34,145 @@ func (s *StringSlice) Scan(value any) error {
 	return json.Unmarshal(bytes, s)
 }
 
+// ArchSlices holds the architecture specific values for list fields in a .SRCINFO, i.e. `depends_x86_64`. the
+// outer key is the field name (`depends`) and the inner key is the architecture (`x86_64`).
+type ArchSlices map[string]map[string][]string
+
+func (a ArchSlices) Value() (driver.Value, error) {
+	if len(a) == 0 {
+		return "{}", nil
+	}
+	return json.Marshal(a)
+}
+
+func (a *ArchSlices) Scan(value any) error {
+	if value == nil {
+		*a = ArchSlices{}
+		return nil
+	}
+
+	var bytes []byte
+	switch v := value.(type) {
+	case []byte:
+		bytes = v
+	case string:
+		bytes = []byte(v)
+	default:
+		return fmt.Errorf("failed to unmarshal ArchSlices value: %v (type: %T)", value, value)
+	}
+
+	return json.Unmarshal(bytes, a)
+}
+
+// Add appends a value to the list for the given field and architecture
+func (a ArchSlices) Add(field, arch, value string) {
+	if a[field] == nil {
+		a[field] = map[string][]string{}
+	}
+	a[field][arch] = append(a[field][arch], value)
+}
+
+// Get returns the values for the given field and architecture
+func (a ArchSlices) Get(field, arch string) []string {
+	return a[field][arch]
+}
+
 type PackageInfo struct {
 	Id             int64       `gorm:"primaryKey;autoIncrement" json:"ID"`
 	Name           string      `gorm:"uniqueIndex;not null" json:"Name"`
 	PackageBaseID  int64       `json:"PackageBaseID"`
 	PackageBase    string      `gorm:"index" json:"PackageBase"`
 	Version        string      `json:"Version"`
+	Epoch          string      `json:"-"`
 	Description    string      `gorm:"index:idx_description" json:"Description"`
 	Url            string      `json:"URL"`
 	NumVotes       int64       `json:"NumVotes"`
 	Popularity     float64     `json:"Popularity"`
 	OutOfDate      *int64      `json:"OutOfDate"`
 	Maintainer     string      `gorm:"index" json:"Maintainer"`
+	Submitter      string      `gorm:"index" json:"Submitter"`
+	CoMaintainers  StringSlice `gorm:"type:text" json:"CoMaintainers"`
 	FirstSubmitted int64       `json:"FirstSubmitted"`
 	LastModified   int64       `json:"LastModified"`
 	UrlPath        string      `json:"URLPath"`
 	Depends        StringSlice `gorm:"type:text" json:"Depends"`
 	MakeDepends    StringSlice `gorm:"type:text" json:"MakeDepends"`
+	CheckDepends   StringSlice `gorm:"type:text" json:"CheckDepends"`
+	OptDepends     StringSlice `gorm:"type:text" json:"OptDepends"`
+	Provides       StringSlice `gorm:"type:text" json:"Provides"`
+	Conflicts      StringSlice `gorm:"type:text" json:"Conflicts"`
+	Replaces       StringSlice `gorm:"type:text" json:"Replaces"`
+	Groups         StringSlice `gorm:"type:text" json:"Groups"`
+	Backup         StringSlice `gorm:"type:text" json:"-"`
 	License        StringSlice `gorm:"type:text" json:"License"`
 	Keywords       StringSlice `gorm:"type:text" json:"Keywords"`
+	ArchSpecific   ArchSlices  `gorm:"type:text" json:"-"`
 }
 
 // set the tablename so gorm doesn't mess it up
 func (PackageInfo) TableName() string {
 	return "package_info"
 }
+
+// MergeArch appends the architecture specific values for the given arch onto the generic fields, i.e. the values
+// of `depends_x86_64` get added onto `Depends`. this should only be used on packages that are about to be returned
+// to a client, never on ones that will get written back to the database.
+func (pkg *PackageInfo) MergeArch(arch string) {
+	pkg.Depends = append(pkg.Depends, pkg.ArchSpecific.Get("depends", arch)...)
+	pkg.MakeDepends = append(pkg.MakeDepends, pkg.ArchSpecific.Get("makedepends", arch)...)
+	pkg.CheckDepends = append(pkg.CheckDepends, pkg.ArchSpecific.Get("checkdepends", arch)...)
+	pkg.OptDepends = append(pkg.OptDepends, pkg.ArchSpecific.Get("optdepends", arch)...)
+	pkg.Provides = append(pkg.Provides, pkg.ArchSpecific.Get("provides", arch)...)
+	pkg.Conflicts = append(pkg.Conflicts, pkg.ArchSpecific.Get("conflicts", arch)...)
+	pkg.Replaces = append(pkg.Replaces, pkg.ArchSpecific.Get("replaces", arch)...)
+}
+
+// Branch is the commit that a branch in the mirror pointed at the last time it was successfully processed, so that
+// unchanged branches can be skipped on the next populate
+type Branch struct {
+	Name   string `gorm:"primaryKey"`
+	Commit string `gorm:"not null"`
+}
+
+func (Branch) TableName() string {
+	return "branches"
+}
+
+const (
+	RelationDepends      = "depends"
+	RelationMakeDepends  = "makedepends"
+	RelationCheckDepends = "checkdepends"
+	RelationOptDepends   = "optdepends"
+	RelationProvides     = "provides"
+	RelationConflicts    = "conflicts"
+	RelationReplaces     = "replaces"
+)
+
+// PackageRelation is a single normalized entry from one of a package's relation lists, i.e. `foo>=1.2` in `depends`
+// is stored with a kind of `depends`, a name of `foo`, an operator of `>=` and a version of `1.2`. these are kept in
+// their own table so that reverse lookups ("what depends on foo?") can use an index rather than scanning every row.
+type PackageRelation struct {
+	Id        int64  `gorm:"primaryKey;autoIncrement"`
+	PackageId int64  `gorm:"index;not null"`
+	Kind      string `gorm:"index:idx_relation_name_kind,priority:2;not null"`
+	Name      string `gorm:"index:idx_relation_name_kind,priority:1;not null"`
+	Operator  string
+	Version   string
+	// the architecture this relation applies to, or empty if it applies to all of them
+	Arch string
+}
+
+func (PackageRelation) TableName() string {
+	return "package_relations"
+}
+
+// PackageBase gives every package base a stable id, which is returned as the PackageBaseID of its packages. rows are
+// never removed, so a package base keeps the same id across populates, even if it gets deleted and later comes back
+type PackageBase struct {
+	Id   int64  `gorm:"primaryKey;autoIncrement"`
+	Name string `gorm:"uniqueIndex;not null"`
+}
+
+func (PackageBase) TableName() string {
+	return "package_bases"
+}
@@ -0,0 +1,255 @@
+package database
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// the fts5 table is kept in sync with package_info by triggers, so every write populate makes to package_info is
+// reflected in the index within the same transaction
+var searchTriggers = []string{
+	`CREATE TRIGGER IF NOT EXISTS package_search_insert AFTER INSERT ON package_info BEGIN
+		INSERT INTO package_search(rowid, name, description, keywords) VALUES (new.id, new.name, new.description, new.keywords);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS package_search_update AFTER UPDATE ON package_info BEGIN
+		DELETE FROM package_search WHERE rowid = old.id;
+		INSERT INTO package_search(rowid, name, description, keywords) VALUES (new.id, new.name, new.description, new.keywords);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS package_search_delete AFTER DELETE ON package_info BEGIN
+		DELETE FROM package_search WHERE rowid = old.id;
+	END`,
+}
+
+var searchTriggerNames = []string{"package_search_insert", "package_search_update", "package_search_delete"}
+
+// setupSearchIndex creates the fts5 table used for name-desc searches, along with the triggers that keep it up to
+// date. fts5 is only available when built with the `sqlite_fts5` tag. without it, false is returned, any existing
+// triggers are dropped so that writes to package_info keep working, and searches fall back to LIKE matching.
+func setupSearchIndex(db *gorm.DB, logger *slog.Logger) (bool, error) {
+	// this is expected to fail without fts5, so don't let gorm log it as an error
+	quiet := db.Session(&gorm.Session{Logger: gormlogger.Default.LogMode(gormlogger.Silent)})
+	// the create is skipped if the table already exists, even without fts5, so the table gets queried afterwards to
+	// make sure it can actually be used
+	err := quiet.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS package_search USING fts5(name, description, keywords, tokenize = 'unicode61 remove_diacritics 2')").Error
+	if err == nil {
+		err = quiet.Exec("SELECT 1 FROM package_search LIMIT 0").Error
+	}
+	if err != nil {
+		if !strings.Contains(err.Error(), "no such module: fts5") {
+			return false, fmt.Errorf("failed to create search index: %w", err)
+		}
+
+		logger.Warn("sqlite was built without fts5, falling back to LIKE searches. build with `-tags sqlite_fts5` to enable full-text search")
+
+		for _, name := range searchTriggerNames {
+			if err := db.Exec(fmt.Sprintf("DROP TRIGGER IF EXISTS %s", name)).Error; err != nil {
+				return false, fmt.Errorf("failed to drop search trigger: %w", err)
+			}
+		}
+
+		return false, nil
+	}
+
+	// if the triggers are missing, the index is either brand new or was left behind while running without fts5.
+	// either way it needs to be rebuilt from package_info
+	var triggers int64
+	if err := db.Raw("SELECT count(*) FROM sqlite_master WHERE type = 'trigger' AND name IN ?", searchTriggerNames).Scan(&triggers).Error; err != nil {
+		return false, fmt.Errorf("failed to check search triggers: %w", err)
+	}
+
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		if triggers != int64(len(searchTriggerNames)) {
+			logger.Info("rebuilding search index")
+
+			if err := tx.Exec("DELETE FROM package_search").Error; err != nil {
+				return err
+			}
+			if err := tx.Exec("INSERT INTO package_search(rowid, name, description, keywords) SELECT id, name, description, keywords FROM package_info").Error; err != nil {
+				return err
+			}
+		}
+
+		for _, trigger := range searchTriggers {
+			if err := tx.Exec(trigger).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}); err != nil {
+		return false, fmt.Errorf("failed to set up search index: %w", err)
+	}
+
+	return true, nil
+}
+
+// ftsQuery builds an fts5 match query out of a search, where every word has to match the start of a token in the
+// name, description or keywords. each word is quoted so that anything in it is treated as text rather than syntax
+func ftsQuery(words []string) string {
+	terms := make([]string, 0, len(words))
+	for _, w := range words {
+		terms = append(terms, `"`+strings.ReplaceAll(w, `"`, `""`)+`"*`)
+	}
+	return strings.Join(terms, " AND ")
+}
+
+// the ways packages can be ordered in a search. the default, when no sort is given, is by relevance
+const (
+	SortName         = "name"
+	SortVotes        = "votes"
+	SortPopularity   = "popularity"
+	SortLastModified = "modified"
+	SortVersion      = "version"
+)
+
+var sortColumns = map[string]string{
+	SortName:         "package_info.name",
+	SortVotes:        "package_info.num_votes",
+	SortPopularity:   "package_info.popularity",
+	SortLastModified: "package_info.last_modified",
+	SortVersion:      "package_info.version COLLATE vercmp",
+}
+
+// SearchOptions controls which page of a search is returned, and how it is ordered
+type SearchOptions struct {
+	// the maximum number of packages to return, or zero for no limit
+	Limit  int
+	Offset int
+	// one of the Sort values, or empty to order by relevance
+	Sort string
+	Desc bool
+}
+
+// SearchPackages searches for packages the same way the aur's rpc does, where by is one of the rpc's search-by
+// values (`name`, `name-desc`, `maintainer`, `depends`, `keywords`, etc). opts may be nil, in which case every match
+// is returned ordered by relevance.
+func (db *Database) SearchPackages(by, term string, opts *SearchOptions) ([]PackageInfo, error) {
+	if opts == nil {
+		opts = &SearchOptions{}
+	}
+
+	tx, relevance, err := db.searchQuery(by, term)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Sort != "" {
+		column, ok := sortColumns[opts.Sort]
+		if !ok {
+			return nil, fmt.Errorf("invalid sort %s", opts.Sort)
+		}
+
+		dir := "ASC"
+		if opts.Desc {
+			dir = "DESC"
+		}
+
+		// the name is unique, so ties always come back in the same order and pages never overlap
+		tx = tx.Order(fmt.Sprintf("%s %s, package_info.name", column, dir))
+	} else {
+		tx = tx.Clauses(relevance)
+	}
+
+	if opts.Limit > 0 {
+		tx = tx.Limit(opts.Limit)
+	}
+	if opts.Offset > 0 {
+		tx = tx.Offset(opts.Offset)
+	}
+
+	var pkgs []PackageInfo
+	if err := tx.Select("package_info.*").Find(&pkgs).Error; err != nil {
+		return nil, err
+	}
+	return pkgs, nil
+}
+
+// CountSearchResults returns the total number of packages that match a search, ignoring any limit
+func (db *Database) CountSearchResults(by, term string) (int64, error) {
+	tx, _, err := db.searchQuery(by, term)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int64
+	if err := tx.Count(&count).Error; err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// searchQuery builds the query that matches every package for the given search, along with the order results
+// should be returned in by default
+func (db *Database) searchQuery(by, term string) (*gorm.DB, clause.OrderBy, error) {
+	byName := clause.OrderBy{Columns: []clause.OrderByColumn{{Column: clause.Column{Table: "package_info", Name: "name"}}}}
+
+	switch by {
+	case "name":
+		tx := db.db.Model(&PackageInfo{}).Where("package_info.name LIKE ? ESCAPE '\\'", "%"+escapeLike(term)+"%")
+		return tx, relevanceOrder("package_info.name", term, "package_info.name"), nil
+	case "name-desc":
+		tx, order := db.nameDescQuery(term)
+		return tx, order, nil
+	case "maintainer":
+		return db.db.Model(&PackageInfo{}).Where("package_info.maintainer = ?", term), byName, nil
+	case "submitter":
+		return db.db.Model(&PackageInfo{}).Where("package_info.submitter = ?", term), byName, nil
+	case "comaintainers":
+		tx, err := db.listValueFilter("co_maintainers", term)
+		return tx, byName, err
+	case "keywords", "groups":
+		tx, err := db.listValueFilter(by, term)
+		return tx, byName, err
+	case RelationDepends, RelationMakeDepends, RelationOptDepends, RelationCheckDepends, RelationProvides,
+		RelationConflicts, RelationReplaces:
+		return db.relationFilter(by, term), byName, nil
+	default:
+		return nil, clause.OrderBy{}, fmt.Errorf("invalid search by %s", by)
+	}
+}
+
+// nameDescQuery matches packages by their name and description. multi-word queries only match packages that contain
+// every word, like the aur does. results are ordered by relevance, with an exact name match first, then names
+// starting with the query, then everything else by how well it matched.
+func (db *Database) nameDescQuery(query string) (*gorm.DB, clause.OrderBy) {
+	tx := db.db.Model(&PackageInfo{})
+
+	words := strings.Fields(query)
+	if len(words) == 0 {
+		return tx.Where("FALSE"), relevanceOrder("package_info.name", query, "package_info.name")
+	}
+
+	if db.fts {
+		tx = tx.
+			Joins("JOIN package_search ON package_search.rowid = package_info.id").
+			Where("package_search MATCH ?", ftsQuery(words))
+		return tx, relevanceOrder("package_info.name", query, "bm25(package_search, 10.0, 1.0, 2.0)")
+	}
+
+	for _, w := range words {
+		term := "%" + escapeLike(w) + "%"
+		tx = tx.Where("(package_info.name LIKE ? ESCAPE '\\' OR package_info.description LIKE ? ESCAPE '\\')", term, term)
+	}
+	return tx, relevanceOrder("package_info.name", query, "package_info.name")
+}
+
+// relevanceOrder orders exact name matches first, then names that start with the query, and then by the given
+// fallback ordering
+func relevanceOrder(nameColumn, query, fallback string) clause.OrderBy {
+	return clause.OrderBy{
+		Expression: clause.Expr{
+			SQL:                fmt.Sprintf("CASE WHEN %s = ? THEN 0 WHEN %s LIKE ? ESCAPE '\\' THEN 1 ELSE 2 END, %s", nameColumn, nameColumn, fallback),
+			Vars:               []any{query, escapeLike(query) + "%"},
+			WithoutParentheses: true,
+		},
+	}
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,144 @@
+package gitrepo
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// execBlobReader reads file contents out of the repo through a single long-running `git cat-file --batch` process,
+// rather than starting a new `git show` for every file
+type execBlobReader struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+}
+
+func (r *execRepo) NewBlobReader() (BlobReader, error) {
+	return r.newBlobReader()
+}
+
+func (r *execRepo) newBlobReader() (*execBlobReader, error) {
+	cmd := exec.Command("git", "-C", r.repoPath, "cat-file", "--batch")
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cat-file stdin: %w", err)
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cat-file stdout: %w", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start cat-file: %w", err)
+	}
+
+	return &execBlobReader{
+		cmd:    cmd,
+		stdin:  stdin,
+		stdout: bufio.NewReaderSize(stdout, 64*1024),
+	}, nil
+}
+
+func (b *execBlobReader) GetFileContent(branch, filePath string) (string, error) {
+	_, typ, data, err := b.readObject(fmt.Sprintf("refs/heads/%s:%s", branch, filePath))
+	if err != nil {
+		return "", fmt.Errorf("failed to get file content for branch %s: %w", branch, err)
+	}
+
+	if typ != "blob" {
+		return "", fmt.Errorf("failed to get file content for branch %s: object is a %s, not a blob", branch, typ)
+	}
+
+	return string(data), nil
+}
+
+func (b *execBlobReader) GetRecentHistory(branch string, window time.Duration) ([]Commit, error) {
+	var commits []Commit
+	var since time.Time
+
+	rev := "refs/heads/" + branch
+	for rev != "" {
+		commit, err := b.readCommit(rev)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get history for branch %s: %w", branch, err)
+		}
+
+		if len(commits) == 0 {
+			since = commit.Committer.When.Add(-window)
+		} else if commit.Committer.When.Before(since) {
+			break
+		}
+		commits = append(commits, *commit)
+
+		rev = ""
+		if len(commit.Parents) > 0 {
+			rev = commit.Parents[0]
+		}
+	}
+
+	return commits, nil
+}
+
+// readCommit reads the commit that the revision points at
+func (b *execBlobReader) readCommit(rev string) (*Commit, error) {
+	oid, typ, data, err := b.readObject(rev)
+	if err != nil {
+		return nil, err
+	}
+
+	if typ != "commit" {
+		return nil, fmt.Errorf("%s is a %s, not a commit", oid, typ)
+	}
+
+	return parseCommit(oid, data)
+}
+
+// readObject reads the object that the revision points at, returning its id, type and contents
+func (b *execBlobReader) readObject(rev string) (oid, typ string, data []byte, err error) {
+	if _, err := fmt.Fprintf(b.stdin, "%s\n", rev); err != nil {
+		return "", "", nil, fmt.Errorf("failed to write to cat-file: %w", err)
+	}
+
+	// the header is either `<oid> <type> <size>`, or `<object> missing` if the object doesn't exist
+	header, err := b.stdout.ReadString('\n')
+	if err != nil {
+		return "", "", nil, fmt.Errorf("failed to read cat-file header: %w", err)
+	}
+	header = strings.TrimSuffix(header, "\n")
+
+	pts := strings.Fields(header)
+	if len(pts) == 2 && pts[1] == "missing" {
+		return "", "", nil, ErrObjectNotFound
+	}
+	if len(pts) != 3 {
+		return "", "", nil, fmt.Errorf("cat-file failed: %s", header)
+	}
+
+	size, err := strconv.Atoi(pts[2])
+	if err != nil {
+		return "", "", nil, fmt.Errorf("invalid size in cat-file header %q: %w", header, err)
+	}
+
+	// the contents are followed by a newline that isn't part of the object
+	buf := make([]byte, size+1)
+	if _, err := io.ReadFull(b.stdout, buf); err != nil {
+		return "", "", nil, fmt.Errorf("failed to read cat-file contents: %w", err)
+	}
+
+	return pts[0], pts[1], buf[:size], nil
+}
+
+// Close stops the cat-file process
+func (b *execBlobReader) Close() error {
+	if err := b.stdin.Close(); err != nil {
+		return err
+	}
+	return b.cmd.Wait()
+}
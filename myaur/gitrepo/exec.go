@@ -0,0 +1,297 @@
+package gitrepo
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// execRepo implements Repo by shelling out to the git binary
+type execRepo struct {
+	logger     *slog.Logger
+	repoPath   string
+	aurRepoUrl string
+}
+
+func (r *execRepo) EnsureRepo() error {
+	if _, err := os.Stat(r.repoPath); os.IsNotExist(err) {
+		r.logger.Info("aur repo does not exist, cloning...", "path", r.repoPath)
+		return r.clone()
+	}
+
+	r.logger.Info("aur repo exists, fetching updates...", "path", r.repoPath)
+	return r.fetch()
+}
+
+func (r *execRepo) clone() error {
+	cmd := exec.Command("git", "clone", "--mirror", r.aurRepoUrl, r.repoPath)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to clone repo: %w", err)
+	}
+
+	r.logger.Info("repo cloned successfully")
+	return nil
+}
+
+func (r *execRepo) fetch() error {
+	cmd := exec.Command("git", "-C", r.repoPath, "fetch", "--all", "--prune")
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to fetch updates: %w", err)
+	}
+
+	r.logger.Info("repo updated successfully")
+	return nil
+}
+
+func (r *execRepo) ListBranchHeads() (map[string]string, error) {
+	cmd := exec.Command("git", "-C", r.repoPath, "for-each-ref", "--format=%(objectname) %(refname:short)", "refs/heads/")
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list branch heads: %w", err)
+	}
+
+	heads := map[string]string{}
+	scanner := bufio.NewScanner(strings.NewReader(string(output)))
+	for scanner.Scan() {
+		commit, branch, found := strings.Cut(strings.TrimSpace(scanner.Text()), " ")
+		if !found || branch == "" {
+			continue
+		}
+		heads[branch] = commit
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error scanning branch heads: %w", err)
+	}
+
+	r.logger.Info("found branch heads", "count", len(heads))
+	return heads, nil
+}
+
+func (r *execRepo) GetRootCommits(branches []string) (map[string]Commit, error) {
+	roots := make(map[string]Commit, len(branches))
+
+	// a root shared by several branches only gets reported for one of them, so the rest get looked up again
+	remaining := branches
+	for len(remaining) > 0 {
+		found, err := r.logRootCommits(remaining)
+		if err != nil {
+			return nil, err
+		}
+		if len(found) == 0 {
+			return nil, fmt.Errorf("failed to get root commits: none found for %d branches", len(remaining))
+		}
+
+		var next []string
+		for _, branch := range remaining {
+			if commit, ok := found[branch]; ok {
+				roots[branch] = commit
+			} else {
+				next = append(next, branch)
+			}
+		}
+		remaining = next
+	}
+
+	return roots, nil
+}
+
+// logRootCommits finds the roots of every branch with a single `git log`, rather than starting a process for each
+// branch. %S is the branch that each root was reached from
+func (r *execRepo) logRootCommits(branches []string) (map[string]Commit, error) {
+	cmd := exec.Command("git", "-C", r.repoPath, "log", "--stdin", "--first-parent", "--max-parents=0",
+		"--format=%H%x00%S%x00%an%x00%ae%x00%at%x00%cn%x00%ce%x00%ct")
+
+	var stdin strings.Builder
+	for _, branch := range branches {
+		fmt.Fprintf(&stdin, "refs/heads/%s\n", branch)
+	}
+	cmd.Stdin = strings.NewReader(stdin.String())
+
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get root commits: %w", err)
+	}
+
+	roots := map[string]Commit{}
+	for line := range strings.SplitSeq(strings.TrimSpace(string(output)), "\n") {
+		pts := strings.Split(line, "\x00")
+		if len(pts) != 8 {
+			continue
+		}
+
+		author, err := parseLogSignature(pts[2], pts[3], pts[4])
+		if err != nil {
+			return nil, fmt.Errorf("failed to get root commits: %w", err)
+		}
+		committer, err := parseLogSignature(pts[5], pts[6], pts[7])
+		if err != nil {
+			return nil, fmt.Errorf("failed to get root commits: %w", err)
+		}
+
+		roots[strings.TrimPrefix(pts[1], "refs/heads/")] = Commit{
+			Hash:      pts[0],
+			Author:    author,
+			Committer: committer,
+		}
+	}
+
+	return roots, nil
+}
+
+// parseLogSignature builds a Signature out of the name, email and unix time that `git log` printed
+func parseLogSignature(name, email, unix string) (Signature, error) {
+	secs, err := strconv.ParseInt(unix, 10, 64)
+	if err != nil {
+		return Signature{}, fmt.Errorf("invalid time %q: %w", unix, err)
+	}
+	return Signature{Name: name, Email: email, When: time.Unix(secs, 0).UTC()}, nil
+}
+
+func (r *execRepo) ResolveBranch(branch string) (string, error) {
+	cmd := exec.Command("git", "-C", r.repoPath, "show-ref", "--verify", "--hash", fmt.Sprintf("refs/heads/%s", branch))
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve branch %s: %w", branch, err)
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
+
+func (r *execRepo) ListTags(branch string) ([]Tag, error) {
+	// the peeled object name is only set for annotated tags
+	cmd := exec.Command("git", "-C", r.repoPath, "for-each-ref", "--merged", fmt.Sprintf("refs/heads/%s", branch),
+		"--format=%(objectname) %(refname) %(*objectname)", "refs/tags/")
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tags for branch %s: %w", branch, err)
+	}
+
+	var tags []Tag
+	for line := range strings.SplitSeq(strings.TrimSpace(string(output)), "\n") {
+		pts := strings.Fields(line)
+		if len(pts) < 2 {
+			continue
+		}
+
+		tag := Tag{Name: pts[1], Hash: pts[0]}
+		if len(pts) > 2 {
+			tag.Peeled = pts[2]
+		}
+		tags = append(tags, tag)
+	}
+
+	return tags, nil
+}
+
+func (r *execRepo) GetFileContent(branch, filePath string) (string, error) {
+	ref := filepath.Join("refs/heads", branch)
+	gitPath := fmt.Sprintf("%s:%s", ref, filePath)
+
+	cmd := exec.Command("git", "-C", r.repoPath, "show", gitPath)
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to get file content for branch %s: %w", branch, err)
+	}
+
+	return string(output), nil
+}
+
+func (r *execRepo) ReadObject(hash string) (string, []byte, error) {
+	blobs, err := r.newBlobReader()
+	if err != nil {
+		return "", nil, err
+	}
+	defer blobs.Close()
+
+	_, typ, data, err := blobs.readObject(hash)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read object %s: %w", hash, err)
+	}
+
+	return typ, data, nil
+}
+
+func (r *execRepo) ListObjects(hashes []string) ([]string, error) {
+	// the hashes go in on stdin, so there's no limit on how many there can be
+	cmd := exec.Command("git", "-C", r.repoPath, "rev-list", "--objects", "--stdin")
+	cmd.Stdin = strings.NewReader(strings.Join(hashes, "\n") + "\n")
+
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects: %w", err)
+	}
+
+	// each line is the hash, followed by the path for trees and blobs
+	var objects []string
+	for line := range strings.SplitSeq(strings.TrimSpace(string(output)), "\n") {
+		if hash, _, _ := strings.Cut(line, " "); hash != "" {
+			objects = append(objects, hash)
+		}
+	}
+
+	return objects, nil
+}
+
+func (r *execRepo) UploadPackCapabilities() []string {
+	// NOTE: these were the ones claude kept adding until yay didn't yell at me anymore. not sure if they are all needed though
+	return []string{"multi_ack", "multi_ack_detailed", "thin-pack", "side-band", "side-band-64k", "ofs-delta", "shallow", "deepen-since", "deepen-not", "no-progress", "include-tag"}
+}
+
+func (r *execRepo) UploadPack(ctx context.Context, req io.Reader, resp io.Writer) error {
+	return r.uploadPack(ctx, req, resp, false)
+}
+
+func (r *execRepo) UploadPackV2Capabilities() []string {
+	// ref-in-want is left out on purpose. upload-pack sends wanted-refs before shallow-info, and clients that asked
+	// for both (i.e. `git clone --depth 1`) refuse the response because they expect them the other way around
+	return []string{"shallow", "filter"}
+}
+
+func (r *execRepo) UploadPackV2(ctx context.Context, req io.Reader, resp io.Writer) error {
+	return r.uploadPack(ctx, req, resp, true)
+}
+
+// uploadPack runs `git upload-pack --stateless-rpc`, speaking either protocol v0 or v2
+func (r *execRepo) uploadPack(ctx context.Context, req io.Reader, resp io.Writer, v2 bool) error {
+	var args []string
+	if v2 {
+		// partial clones are off by default, so they need to be turned on to match what gets advertised
+		args = append(args, "-c", "uploadpack.allowFilter=true")
+	}
+	args = append(args, "upload-pack", "--stateless-rpc", r.repoPath)
+
+	cmd := exec.CommandContext(ctx, "git", args...)
+	if v2 {
+		cmd.Env = append(os.Environ(), "GIT_PROTOCOL=version=2")
+	}
+	cmd.Stdin = req
+	cmd.Stdout = resp
+	// if the context is cancelled while copying the request or response, don't wait on the client forever once
+	// upload-pack has been killed
+	cmd.WaitDelay = 5 * time.Second
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("upload-pack failed: %w: %s", err, stderr.String())
+	}
+
+	return nil
+}
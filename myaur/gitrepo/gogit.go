@@ -0,0 +1,426 @@
+package gitrepo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/revlist"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+)
+
+// goGitRepo implements Repo in process with go-git, so the git binary doesn't need to be installed
+type goGitRepo struct {
+	logger     *slog.Logger
+	repoPath   string
+	aurRepoUrl string
+}
+
+func (r *goGitRepo) EnsureRepo() error {
+	if _, err := os.Stat(r.repoPath); os.IsNotExist(err) {
+		r.logger.Info("aur repo does not exist, cloning...", "path", r.repoPath)
+		return r.clone()
+	}
+
+	r.logger.Info("aur repo exists, fetching updates...", "path", r.repoPath)
+	return r.fetch()
+}
+
+func (r *goGitRepo) clone() error {
+	if _, err := git.PlainClone(r.repoPath, true, &git.CloneOptions{
+		URL:      r.aurRepoUrl,
+		Mirror:   true,
+		Progress: os.Stdout,
+	}); err != nil {
+		return fmt.Errorf("failed to clone repo: %w", err)
+	}
+
+	r.logger.Info("repo cloned successfully")
+	return nil
+}
+
+func (r *goGitRepo) fetch() error {
+	repo, err := r.open()
+	if err != nil {
+		return err
+	}
+
+	// the mirror clone sets up the remote to fetch every ref, so we only need to ask it to prune
+	if err := repo.Fetch(&git.FetchOptions{
+		Prune:    true,
+		Force:    true,
+		Progress: os.Stdout,
+	}); err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return fmt.Errorf("failed to fetch updates: %w", err)
+	}
+
+	r.logger.Info("repo updated successfully")
+	return nil
+}
+
+func (r *goGitRepo) open() (*git.Repository, error) {
+	repo, err := git.PlainOpen(r.repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repo: %w", err)
+	}
+	return repo, nil
+}
+
+func (r *goGitRepo) ListBranchHeads() (map[string]string, error) {
+	repo, err := r.open()
+	if err != nil {
+		return nil, err
+	}
+
+	iter, err := repo.Branches()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list branch heads: %w", err)
+	}
+
+	heads := map[string]string{}
+	if err := iter.ForEach(func(ref *plumbing.Reference) error {
+		heads[ref.Name().Short()] = ref.Hash().String()
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to list branch heads: %w", err)
+	}
+
+	r.logger.Info("found branch heads", "count", len(heads))
+	return heads, nil
+}
+
+func (r *goGitRepo) GetRootCommits(branches []string) (map[string]Commit, error) {
+	repo, err := r.open()
+	if err != nil {
+		return nil, err
+	}
+
+	roots := make(map[string]Commit, len(branches))
+	for _, branch := range branches {
+		ref, err := repo.Reference(plumbing.NewBranchReferenceName(branch), true)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get root commit for branch %s: %w", branch, err)
+		}
+
+		// the walk happens in process, so there's no process or round trip for each branch
+		c, err := repo.CommitObject(ref.Hash())
+		for err == nil && len(c.ParentHashes) > 0 {
+			c, err = repo.CommitObject(c.ParentHashes[0])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get root commit for branch %s: %w", branch, err)
+		}
+
+		roots[branch] = toCommit(c)
+	}
+
+	return roots, nil
+}
+
+func (r *goGitRepo) ResolveBranch(branch string) (string, error) {
+	repo, err := r.open()
+	if err != nil {
+		return "", err
+	}
+
+	ref, err := repo.Reference(plumbing.NewBranchReferenceName(branch), true)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve branch %s: %w", branch, err)
+	}
+
+	return ref.Hash().String(), nil
+}
+
+func (r *goGitRepo) ListTags(branch string) ([]Tag, error) {
+	repo, err := r.open()
+	if err != nil {
+		return nil, err
+	}
+
+	ref, err := repo.Reference(plumbing.NewBranchReferenceName(branch), true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tags for branch %s: %w", branch, err)
+	}
+
+	head, err := repo.CommitObject(ref.Hash())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get commit for branch %s: %w", branch, err)
+	}
+
+	iter, err := repo.Tags()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tags for branch %s: %w", branch, err)
+	}
+
+	var tags []Tag
+	if err := iter.ForEach(func(ref *plumbing.Reference) error {
+		tag := Tag{Name: ref.Name().String(), Hash: ref.Hash().String()}
+
+		// annotated tags point at a tag object, which points at the commit
+		target := ref.Hash()
+		if obj, err := repo.TagObject(ref.Hash()); err == nil {
+			target = obj.Target
+			tag.Peeled = target.String()
+		}
+
+		// tags on anything other than a commit (or on a commit that's not in the mirror) can't be in the history
+		commit, err := repo.CommitObject(target)
+		if err != nil {
+			return nil
+		}
+
+		if commit.Hash == head.Hash {
+			tags = append(tags, tag)
+			return nil
+		}
+
+		ancestor, err := commit.IsAncestor(head)
+		if err != nil {
+			return err
+		}
+		if ancestor {
+			tags = append(tags, tag)
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to list tags for branch %s: %w", branch, err)
+	}
+
+	return tags, nil
+}
+
+func (r *goGitRepo) GetFileContent(branch, filePath string) (string, error) {
+	repo, err := r.open()
+	if err != nil {
+		return "", err
+	}
+
+	return readFile(repo, branch, filePath)
+}
+
+func (r *goGitRepo) ReadObject(hash string) (string, []byte, error) {
+	repo, err := r.open()
+	if err != nil {
+		return "", nil, err
+	}
+
+	obj, err := repo.Storer.EncodedObject(plumbing.AnyObject, plumbing.NewHash(hash))
+	if errors.Is(err, plumbing.ErrObjectNotFound) {
+		return "", nil, ErrObjectNotFound
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read object %s: %w", hash, err)
+	}
+
+	reader, err := obj.Reader()
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read object %s: %w", hash, err)
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read object %s: %w", hash, err)
+	}
+
+	return obj.Type().String(), data, nil
+}
+
+func (r *goGitRepo) ListObjects(hashes []string) ([]string, error) {
+	repo, err := r.open()
+	if err != nil {
+		return nil, err
+	}
+
+	objs := make([]plumbing.Hash, 0, len(hashes))
+	for _, h := range hashes {
+		objs = append(objs, plumbing.NewHash(h))
+	}
+
+	found, err := revlist.Objects(repo.Storer, objs, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects: %w", err)
+	}
+
+	objects := make([]string, 0, len(found))
+	for _, h := range found {
+		objects = append(objects, h.String())
+	}
+
+	return objects, nil
+}
+
+func (r *goGitRepo) NewBlobReader() (BlobReader, error) {
+	// each reader gets its own handle on the repo, since go-git's storage isn't safe to share between goroutines
+	repo, err := r.open()
+	if err != nil {
+		return nil, err
+	}
+
+	return &goGitBlobReader{
+		repo: repo,
+	}, nil
+}
+
+func (r *goGitRepo) UploadPackCapabilities() []string {
+	// go-git's upload-pack only supports these, and will reject a request asking for anything else. notably that
+	// includes shallow, so clients refuse to make shallow clones rather than getting an error halfway through
+	return []string{capability.OFSDelta.String(), fmt.Sprintf("%s=%s", capability.Agent, capability.DefaultAgent())}
+}
+
+func (r *goGitRepo) UploadPack(ctx context.Context, req io.Reader, resp io.Writer) error {
+	path, err := filepath.Abs(r.repoPath)
+	if err != nil {
+		return fmt.Errorf("failed to get repo path: %w", err)
+	}
+
+	ep, err := transport.NewEndpoint(path)
+	if err != nil {
+		return fmt.Errorf("failed to create endpoint: %w", err)
+	}
+
+	session, err := server.DefaultServer.NewUploadPackSession(ep, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create upload-pack session: %w", err)
+	}
+	defer session.Close()
+
+	upReq := packp.NewUploadPackRequest()
+	if err := upReq.Decode(req); err != nil {
+		return fmt.Errorf("failed to decode upload-pack request: %w", err)
+	}
+
+	// go-git only decodes the wants, so the haves that come after them need to be read here
+	scanner := pktline.NewScanner(req)
+	for scanner.Scan() {
+		line := bytes.TrimSuffix(scanner.Bytes(), []byte("\n"))
+		if hash, ok := bytes.CutPrefix(line, []byte("have ")); ok {
+			upReq.Haves = append(upReq.Haves, plumbing.NewHash(string(hash)))
+		}
+		if bytes.Equal(line, []byte("done")) {
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read upload-pack haves: %w", err)
+	}
+
+	upResp, err := session.UploadPack(ctx, upReq)
+	if err != nil {
+		return fmt.Errorf("upload-pack failed: %w", err)
+	}
+	defer upResp.Close()
+
+	if err := upResp.Encode(resp); err != nil {
+		return fmt.Errorf("failed to write upload-pack response: %w", err)
+	}
+
+	return nil
+}
+
+func (r *goGitRepo) UploadPackV2Capabilities() []string {
+	// go-git's server only speaks protocol v0, so clients need to fall back to it
+	return nil
+}
+
+func (r *goGitRepo) UploadPackV2(ctx context.Context, req io.Reader, resp io.Writer) error {
+	return fmt.Errorf("protocol v2 is not supported by the go-git backend")
+}
+
+type goGitBlobReader struct {
+	repo *git.Repository
+}
+
+func (b *goGitBlobReader) GetFileContent(branch, filePath string) (string, error) {
+	return readFile(b.repo, branch, filePath)
+}
+
+func (b *goGitBlobReader) GetRecentHistory(branch string, window time.Duration) ([]Commit, error) {
+	ref, err := b.repo.Reference(plumbing.NewBranchReferenceName(branch), true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get history for branch %s: %w", branch, err)
+	}
+
+	var commits []Commit
+	var since time.Time
+
+	hash := ref.Hash()
+	for !hash.IsZero() {
+		c, err := b.repo.CommitObject(hash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get history for branch %s: %w", branch, err)
+		}
+
+		commit := toCommit(c)
+		if len(commits) == 0 {
+			since = commit.Committer.When.Add(-window)
+		} else if commit.Committer.When.Before(since) {
+			break
+		}
+		commits = append(commits, commit)
+
+		hash = plumbing.ZeroHash
+		if len(c.ParentHashes) > 0 {
+			hash = c.ParentHashes[0]
+		}
+	}
+
+	return commits, nil
+}
+
+// toCommit converts a go-git commit into a Commit
+func toCommit(c *object.Commit) Commit {
+	commit := Commit{
+		Hash: c.Hash.String(),
+		Author: Signature{
+			Name:  c.Author.Name,
+			Email: c.Author.Email,
+			When:  c.Author.When.UTC(),
+		},
+		Committer: Signature{
+			Name:  c.Committer.Name,
+			Email: c.Committer.Email,
+			When:  c.Committer.When.UTC(),
+		},
+	}
+	for _, p := range c.ParentHashes {
+		commit.Parents = append(commit.Parents, p.String())
+	}
+	return commit
+}
+
+func (b *goGitBlobReader) Close() error {
+	return nil
+}
+
+func readFile(repo *git.Repository, branch, filePath string) (string, error) {
+	ref, err := repo.Reference(plumbing.NewBranchReferenceName(branch), true)
+	if err != nil {
+		return "", fmt.Errorf("failed to get file content for branch %s: %w", branch, err)
+	}
+
+	commit, err := repo.CommitObject(ref.Hash())
+	if err != nil {
+		return "", fmt.Errorf("failed to get commit for branch %s: %w", branch, err)
+	}
+
+	file, err := commit.File(filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to get file content for branch %s: %w", branch, err)
+	}
+
+	return file.Contents()
+}
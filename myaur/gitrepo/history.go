@@ -0,0 +1,85 @@
+package gitrepo
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Signature is the author or committer of a commit
+type Signature struct {
+	Name  string
+	Email string
+	When  time.Time
+}
+
+// Commit is the metadata of a single commit, without any of its contents
+type Commit struct {
+	Hash      string
+	Parents   []string
+	Author    Signature
+	Committer Signature
+}
+
+// parseCommit parses the headers of a raw commit object, as returned by `git cat-file`
+func parseCommit(hash string, data []byte) (*Commit, error) {
+	commit := Commit{Hash: hash}
+
+	// the headers end at the first blank line, everything after that is the message
+	headers, _, _ := bytes.Cut(data, []byte("\n\n"))
+
+	for line := range strings.SplitSeq(string(headers), "\n") {
+		// continuation lines of multi-line headers like gpgsig start with a space
+		key, value, found := strings.Cut(line, " ")
+		if !found || key == "" {
+			continue
+		}
+
+		switch key {
+		case "parent":
+			commit.Parents = append(commit.Parents, value)
+		case "author":
+			sig, err := parseSignature(value)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse author of %s: %w", hash, err)
+			}
+			commit.Author = *sig
+		case "committer":
+			sig, err := parseSignature(value)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse committer of %s: %w", hash, err)
+			}
+			commit.Committer = *sig
+		}
+	}
+
+	return &commit, nil
+}
+
+// parseSignature parses a signature like `Jane Doe <jane@example.com> 1700000000 +0100`
+func parseSignature(value string) (*Signature, error) {
+	open := strings.LastIndex(value, "<")
+	end := strings.LastIndex(value, ">")
+	if open == -1 || end < open {
+		return nil, fmt.Errorf("invalid signature %q", value)
+	}
+
+	sig := Signature{
+		Name:  strings.TrimSpace(value[:open]),
+		Email: value[open+1 : end],
+	}
+
+	// the timezone isn't needed, everything gets stored as a unix timestamp
+	fields := strings.Fields(value[end+1:])
+	if len(fields) > 0 {
+		ts, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp in signature %q: %w", value, err)
+		}
+		sig.When = time.Unix(ts, 0).UTC()
+	}
+
+	return &sig, nil
+}
@@ -1,33 +1,92 @@
 package gitrepo
 
 import (
-	"bufio"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/url"
 	"os"
-	"os/exec"
-	"path/filepath"
-	"strings"
+	"time"
 )
 
 const (
 	DefaultAurRepoUrl = "https://github.com/archlinux/aur.git"
 )
 
-type Repo struct {
-	logger     *slog.Logger
-	repoPath   string
-	aurRepoUrl string
+const (
+	// BackendExec shells out to the git binary for every operation
+	BackendExec = "exec"
+	// BackendGoGit uses an in-process git implementation, so the git binary doesn't need to be installed
+	BackendGoGit = "go-git"
+)
+
+// ErrObjectNotFound is returned when an object doesn't exist in the repo
+var ErrObjectNotFound = errors.New("object not found")
+
+// Tag is a tag in the repo
+type Tag struct {
+	// the full name of the tag, i.e. refs/tags/v1.0
+	Name string
+	Hash string
+	// for annotated tags, the commit that the tag object points at. empty for lightweight tags
+	Peeled string
+}
+
+// Repo is a local mirror of the aur repo, where each package is its own branch
+type Repo interface {
+	// EnsureRepo clones the mirror if it doesn't exist yet, and fetches any updates if it does
+	EnsureRepo() error
+	// ListBranchHeads returns every branch along with the commit hash that it currently points at
+	ListBranchHeads() (map[string]string, error)
+	// ResolveBranch returns the commit hash that the branch currently points at
+	ResolveBranch(branch string) (string, error)
+	// ListTags returns every tag that points at a commit in the branch's history
+	ListTags(branch string) ([]Tag, error)
+	// GetFileContent returns the contents of the file at the given path on the given branch
+	GetFileContent(branch, filePath string) (string, error)
+	// ReadObject returns the type and contents of the object with the given hash, or ErrObjectNotFound if it doesn't
+	// exist
+	ReadObject(hash string) (string, []byte, error)
+	// ListObjects returns the hash of every object reachable from the given object hashes, including the objects
+	// themselves
+	ListObjects(hashes []string) ([]string, error)
+	// GetRootCommits returns the commit each branch was created with, following the first parent of each commit
+	GetRootCommits(branches []string) (map[string]Commit, error)
+	// NewBlobReader creates a reader for reading many files out of the repo. each worker should create its own
+	NewBlobReader() (BlobReader, error)
+	// UploadPackCapabilities returns the capabilities that UploadPack supports, for the ref advertisement
+	UploadPackCapabilities() []string
+	// UploadPack runs a stateless upload-pack, reading the client's request from req and writing the response
+	// to resp
+	UploadPack(ctx context.Context, req io.Reader, resp io.Writer) error
+	// UploadPackV2Capabilities returns the features of the protocol v2 fetch command that UploadPackV2 supports, or
+	// nil if protocol v2 isn't supported at all
+	UploadPackV2Capabilities() []string
+	// UploadPackV2 runs a stateless protocol v2 fetch command, reading the client's request from req and writing the
+	// response to resp
+	UploadPackV2(ctx context.Context, req io.Reader, resp io.Writer) error
+}
+
+// BlobReader reads many files out of the repo. it is not safe to use from multiple goroutines
+type BlobReader interface {
+	GetFileContent(branch, filePath string) (string, error)
+	// GetRecentHistory returns the commits on the branch that were committed within window of its latest commit,
+	// newest first. only the first parent of each commit is followed, and the latest commit is always included
+	GetRecentHistory(branch string, window time.Duration) ([]Commit, error)
+	Close() error
 }
 
 type Args struct {
 	RepoPath   string
 	AurRepoUrl string
-	Debug      bool
+	// which git implementation to use, either BackendExec or BackendGoGit. defaults to BackendExec
+	Backend string
+	Debug   bool
 }
 
-func New(args *Args) (*Repo, error) {
+func New(args *Args) (Repo, error) {
 	level := slog.LevelInfo
 	if args.Debug {
 		level = slog.LevelDebug
@@ -45,88 +104,30 @@ func New(args *Args) (*Repo, error) {
 		return nil, fmt.Errorf("failed to parse AUR repo url: %w", err)
 	}
 
+	if args.Backend == "" {
+		args.Backend = BackendExec
+	}
+
 	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
 		Level: level,
 	}))
 
-	logger = logger.With("component", "gitrepo", "aururl", args.AurRepoUrl)
-
-	return &Repo{
-		logger:     logger,
-		repoPath:   args.RepoPath,
-		aurRepoUrl: args.AurRepoUrl,
-	}, nil
-}
-
-func (r *Repo) EnsureRepo() error {
-	if _, err := os.Stat(r.repoPath); os.IsNotExist(err) {
-		r.logger.Info("aur repo does not exist, cloning...", "path", r.repoPath)
-		return r.clone()
+	logger = logger.With("component", "gitrepo", "aururl", args.AurRepoUrl, "backend", args.Backend)
+
+	switch args.Backend {
+	case BackendExec:
+		return &execRepo{
+			logger:     logger,
+			repoPath:   args.RepoPath,
+			aurRepoUrl: args.AurRepoUrl,
+		}, nil
+	case BackendGoGit:
+		return &goGitRepo{
+			logger:     logger,
+			repoPath:   args.RepoPath,
+			aurRepoUrl: args.AurRepoUrl,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown git backend %q, must be one of %s or %s", args.Backend, BackendExec, BackendGoGit)
 	}
-
-	r.logger.Info("aur repo exists, fetching updates...", "path", r.repoPath)
-	return r.fetch()
-}
-
-func (r *Repo) clone() error {
-	cmd := exec.Command("git", "clone", "--mirror", r.aurRepoUrl, r.repoPath)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to clone repo: %w", err)
-	}
-
-	r.logger.Info("repo cloned successfully")
-	return nil
-}
-
-func (r *Repo) fetch() error {
-	cmd := exec.Command("git", "-C", r.repoPath, "fetch", "--all", "--prune")
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to fetch updates: %w", err)
-	}
-
-	r.logger.Info("repo updated successfully")
-	return nil
-}
-
-func (r *Repo) ListBranches() ([]string, error) {
-	cmd := exec.Command("git", "-C", r.repoPath, "for-each-ref", "--format=%(refname:short)", "refs/heads/")
-	output, err := cmd.Output()
-	if err != nil {
-		return nil, fmt.Errorf("failed to list branches: %w", err)
-	}
-
-	var branches []string
-	scanner := bufio.NewScanner(strings.NewReader(string(output)))
-	for scanner.Scan() {
-		branch := strings.TrimSpace(scanner.Text())
-		if branch != "" {
-			branches = append(branches, branch)
-		}
-	}
-
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("error scanning branch list: %w", err)
-	}
-
-	r.logger.Info("found branches", "count", len(branches))
-	return branches, nil
-}
-
-func (r *Repo) GetFileContent(branch, filePath string) (string, error) {
-	ref := filepath.Join("refs/heads", branch)
-	gitPath := fmt.Sprintf("%s:%s", ref, filePath)
-
-	cmd := exec.Command("git", "-C", r.repoPath, "show", gitPath)
-	output, err := cmd.Output()
-	if err != nil {
-		return "", fmt.Errorf("failed to get file content for branch %s: %w", branch, err)
-	}
-
-	return string(output), nil
 }
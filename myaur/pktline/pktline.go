@@ -0,0 +1,139 @@
+// Package pktline reads and writes git's pkt-line format, which every git protocol message is made of. each line is
+// prefixed with its length as four hex digits, and a few special packets with lengths of less than four are used to
+// separate the parts of a message.
+package pktline
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Kind is the type of a packet
+type Kind int
+
+const (
+	// Data is a regular packet with a payload
+	Data Kind = iota
+	// Flush (`0000`) ends a message, or a list of values in protocol v0
+	Flush
+	// Delim (`0001`) separates the sections of a protocol v2 message
+	Delim
+	// ResponseEnd (`0002`) ends a protocol v2 response in stateless connections
+	ResponseEnd
+)
+
+// MaxPayload is the largest payload a single packet can carry
+const MaxPayload = 65516
+
+var ErrPayloadTooLarge = errors.New("pkt-line payload too large")
+
+// Packet is a single pkt-line. only Data packets have a payload
+type Packet struct {
+	Kind    Kind
+	Payload []byte
+}
+
+// Reader reads packets one at a time
+type Reader struct {
+	r *bufio.Reader
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{
+		r: bufio.NewReader(r),
+	}
+}
+
+// ReadPacket reads the next packet. io.EOF is returned if the input ends cleanly between packets
+func (r *Reader) ReadPacket() (Packet, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r.r, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return Packet{}, fmt.Errorf("truncated pkt-line length: %w", err)
+		}
+		return Packet{}, err
+	}
+
+	size, err := strconv.ParseUint(string(header[:]), 16, 16)
+	if err != nil {
+		return Packet{}, fmt.Errorf("invalid pkt-line length %q", header)
+	}
+
+	switch size {
+	case 0:
+		return Packet{Kind: Flush}, nil
+	case 1:
+		return Packet{Kind: Delim}, nil
+	case 2:
+		return Packet{Kind: ResponseEnd}, nil
+	case 3:
+		return Packet{}, fmt.Errorf("invalid pkt-line length %q", header)
+	}
+
+	payload := make([]byte, size-4)
+	if _, err := io.ReadFull(r.r, payload); err != nil {
+		return Packet{}, fmt.Errorf("failed to read pkt-line: %w", err)
+	}
+
+	return Packet{Kind: Data, Payload: payload}, nil
+}
+
+// Remaining returns everything after the last packet that was read, for when the rest of a stream isn't made of
+// packets, like the pack that follows the packfile section of a fetch response
+func (r *Reader) Remaining() io.Reader {
+	return r.r
+}
+
+// Writer writes packets
+type Writer struct {
+	w io.Writer
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		w: w,
+	}
+}
+
+// WritePacket writes a single packet, working out the length from the payload
+func (w *Writer) WritePacket(p Packet) error {
+	switch p.Kind {
+	case Flush:
+		_, err := io.WriteString(w.w, "0000")
+		return err
+	case Delim:
+		_, err := io.WriteString(w.w, "0001")
+		return err
+	case ResponseEnd:
+		_, err := io.WriteString(w.w, "0002")
+		return err
+	}
+
+	if len(p.Payload) > MaxPayload {
+		return ErrPayloadTooLarge
+	}
+
+	if _, err := fmt.Fprintf(w.w, "%04x", len(p.Payload)+4); err != nil {
+		return err
+	}
+	_, err := w.w.Write(p.Payload)
+	return err
+}
+
+// WriteString writes a data packet with the given payload
+func (w *Writer) WriteString(s string) error {
+	return w.WritePacket(Packet{Kind: Data, Payload: []byte(s)})
+}
+
+// Flush writes a flush packet
+func (w *Writer) Flush() error {
+	return w.WritePacket(Packet{Kind: Flush})
+}
+
+// Delim writes a delimiter packet
+func (w *Writer) Delim() error {
+	return w.WritePacket(Packet{Kind: Delim})
+}
@@ -0,0 +1,131 @@
+package pktline
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// encode builds a pkt-line stream out of the given lines by hand, so the tests don't depend on Writer. `0000`, `0001`
+// and `0002` are written as flush, delimiter and response end packets
+func encode(lines ...string) string {
+	var sb strings.Builder
+	for _, line := range lines {
+		switch line {
+		case "0000", "0001", "0002":
+			sb.WriteString(line)
+		default:
+			fmt.Fprintf(&sb, "%04x%s", len(line)+4, line)
+		}
+	}
+	return sb.String()
+}
+
+func TestRoundTrip(t *testing.T) {
+	packets := []Packet{
+		{Kind: Data, Payload: []byte("# service=git-upload-pack\n")},
+		{Kind: Flush},
+		{Kind: Data, Payload: []byte("command=fetch\n")},
+		{Kind: Delim},
+		{Kind: Data, Payload: []byte("want 0123456789abcdef0123456789abcdef01234567\n")},
+		// payloads don't have to end in a newline, or be text at all
+		{Kind: Data, Payload: []byte("\x02\x00binary")},
+		{Kind: Data, Payload: []byte{}},
+		{Kind: ResponseEnd},
+		{Kind: Data, Payload: bytes.Repeat([]byte("a"), MaxPayload)},
+		{Kind: Flush},
+	}
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	for _, p := range packets {
+		if err := w.WritePacket(p); err != nil {
+			t.Fatalf("failed to write %v: %v", p, err)
+		}
+	}
+
+	r := NewReader(&buf)
+	for i, want := range packets {
+		got, err := r.ReadPacket()
+		if err != nil {
+			t.Fatalf("failed to read packet %d: %v", i, err)
+		}
+		if got.Kind != want.Kind || !bytes.Equal(got.Payload, want.Payload) {
+			t.Errorf("packet %d: got %v %q, want %v %q", i, got.Kind, got.Payload, want.Kind, want.Payload)
+		}
+	}
+
+	if _, err := r.ReadPacket(); err != io.EOF {
+		t.Errorf("got %v after the last packet, want io.EOF", err)
+	}
+}
+
+func TestWriteEncoding(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.WriteString("version 2\n")
+	w.Delim()
+	w.WriteString("")
+	w.Flush()
+
+	if got, want := buf.String(), "000eversion 2\n000100040000"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestWriteOversize(t *testing.T) {
+	var buf bytes.Buffer
+	err := NewWriter(&buf).WritePacket(Packet{Kind: Data, Payload: make([]byte, MaxPayload+1)})
+	if !errors.Is(err, ErrPayloadTooLarge) {
+		t.Errorf("got %v, want ErrPayloadTooLarge", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("wrote %d bytes of a packet that was too large", buf.Len())
+	}
+}
+
+func TestReadMalformed(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{name: "not hex", input: "00zzabcd"},
+		{name: "signed length", input: "-001abcd"},
+		{name: "length 3", input: "0003"},
+		{name: "truncated length", input: "00"},
+		{name: "truncated payload", input: "000aabc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewReader(strings.NewReader(tt.input)).ReadPacket()
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if err == io.EOF {
+				t.Error("got a clean io.EOF for malformed input")
+			}
+		})
+	}
+}
+
+func TestRemaining(t *testing.T) {
+	// everything after the packfile line is raw pack data, which may look like anything
+	input := encode("packfile\n") + "PACK\x00\x00\x00\x020000"
+
+	r := NewReader(strings.NewReader(input))
+	if _, err := r.ReadPacket(); err != nil {
+		t.Fatal(err)
+	}
+
+	rest, err := io.ReadAll(r.Remaining())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(rest), "PACK\x00\x00\x00\x020000"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,235 @@
+package pktline
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// UnknownRefError is returned when a request asks for a ref that the RefRewriter doesn't know about
+type UnknownRefError struct {
+	Name string
+}
+
+func (e *UnknownRefError) Error() string {
+	return fmt.Sprintf("unknown ref %s", e.Name)
+}
+
+// UnknownObjectError is returned when a request wants an object that the RefRewriter isn't allowed to hand out. the
+// message is the same one upload-pack gives for an object it won't serve
+type UnknownObjectError struct {
+	Hash string
+}
+
+func (e *UnknownObjectError) Error() string {
+	return fmt.Sprintf("upload-pack: not our ref %s", e.Hash)
+}
+
+// RefRewriter renames the refs in an upload-pack request from the names the client knows them by to the names in the
+// repo that serves the request, and renames them back in the response. it's safe to rewrite the request and the
+// response at the same time
+type RefRewriter struct {
+	resolve func(name string) (string, error)
+	allow   func(hash string) (bool, error)
+
+	mu sync.Mutex
+	// the refs the client asked for by name, in the order it asked for them
+	wanted []wantedRef
+}
+
+type wantedRef struct {
+	client string
+	repo   string
+}
+
+// NewRefRewriter creates a RefRewriter. resolve returns the name in the repo of a ref the client asked for, or an
+// UnknownRefError if the client doesn't have that ref. several of the client's refs may resolve to the same ref in
+// the repo (i.e. HEAD and refs/heads/master). it only gets called for requests that ask for a ref by name. allow
+// reports whether the client may ask for an object by its id, since the repo may hold objects that the client
+// shouldn't be able to see
+func NewRefRewriter(resolve func(name string) (string, error), allow func(hash string) (bool, error)) *RefRewriter {
+	return &RefRewriter{
+		resolve: resolve,
+		allow:   allow,
+	}
+}
+
+// MapResolver returns a resolve function for NewRefRewriter that looks refs up in a map of the client's names to the
+// names in the repo
+func MapResolver(refs map[string]string) func(name string) (string, error) {
+	return func(name string) (string, error) {
+		repoName, ok := refs[name]
+		if !ok {
+			return "", &UnknownRefError{Name: name}
+		}
+		return repoName, nil
+	}
+}
+
+// RewriteRequest copies an upload-pack request from r to w. protocol v2's `want-ref` lines and the `deepen-not` lines
+// from --shallow-exclude name a ref, so those are the only ones that change. object ids, capabilities, shallow and
+// other deepen lines and anything else are copied as is, and every packet keeps a correct length. an UnknownRefError is returned if the client asks for a ref
+// that it doesn't have, and an UnknownObjectError if it wants an object it isn't allowed, without either ever
+// reaching w
+func (rw *RefRewriter) RewriteRequest(w io.Writer, r io.Reader) error {
+	pr := NewReader(r)
+	pw := NewWriter(w)
+
+	for {
+		p, err := pr.ReadPacket()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if p.Kind == Data {
+			line, newline := bytes.CutSuffix(p.Payload, []byte("\n"))
+			if name, ok := bytes.CutPrefix(line, []byte("want-ref ")); ok {
+				repoName, err := rw.want(string(name))
+				if err != nil {
+					return err
+				}
+
+				p.Payload = []byte("want-ref " + repoName)
+				if newline {
+					p.Payload = append(p.Payload, '\n')
+				}
+			} else if name, ok := bytes.CutPrefix(line, []byte("deepen-not ")); ok {
+				repoName, err := rw.deepenNot(string(name))
+				if err != nil {
+					return err
+				}
+
+				p.Payload = []byte("deepen-not " + repoName)
+				if newline {
+					p.Payload = append(p.Payload, '\n')
+				}
+			} else if rest, ok := bytes.CutPrefix(line, []byte("want ")); ok {
+				// in v0 the first want is followed by the client's capabilities
+				hash, _, _ := bytes.Cut(rest, []byte(" "))
+				if err := rw.check(string(hash)); err != nil {
+					return err
+				}
+			}
+		}
+
+		if err := pw.WritePacket(p); err != nil {
+			return err
+		}
+	}
+}
+
+// want records that the client asked for the ref, returning its name in the repo
+func (rw *RefRewriter) want(name string) (string, error) {
+	repoName, err := rw.resolve(name)
+	if err != nil {
+		return "", err
+	}
+
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	rw.wanted = append(rw.wanted, wantedRef{client: name, repo: repoName})
+
+	return repoName, nil
+}
+
+// deepenNotRules are the places upload-pack looks for the ref in a `deepen-not`, in order, since the client sends
+// whatever was given to --shallow-exclude (i.e. `master` or `v1`)
+var deepenNotRules = []string{
+	"%s",
+	"refs/%s",
+	"refs/tags/%s",
+	"refs/heads/%s",
+}
+
+// deepenNot returns the name in the repo of the ref in a `deepen-not`. unlike a `want-ref` it doesn't show up in the
+// response, so it isn't recorded
+func (rw *RefRewriter) deepenNot(name string) (string, error) {
+	for _, rule := range deepenNotRules {
+		repoName, err := rw.resolve(fmt.Sprintf(rule, name))
+		var unknown *UnknownRefError
+		if errors.As(err, &unknown) {
+			continue
+		}
+		return repoName, err
+	}
+	return "", &UnknownRefError{Name: name}
+}
+
+// check returns an UnknownObjectError if the client isn't allowed the object
+func (rw *RefRewriter) check(hash string) error {
+	ok, err := rw.allow(hash)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return &UnknownObjectError{Hash: hash}
+	}
+	return nil
+}
+
+// unwant returns the name the client asked for a ref by, given its name in the repo. each line of wanted-refs
+// answers one `want-ref`, so each name the client asked for only gets used once
+func (rw *RefRewriter) unwant(repoName string) (string, bool) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	for i, ref := range rw.wanted {
+		if ref.repo == repoName {
+			rw.wanted = append(rw.wanted[:i], rw.wanted[i+1:]...)
+			return ref.client, true
+		}
+	}
+	return "", false
+}
+
+// RewriteFetchResponse copies a protocol v2 fetch response from r to w, renaming the refs in the wanted-refs section
+// back to the names the client asked for them by. everything from the packfile section onwards is copied as is, since
+// it's the pack itself
+func (rw *RefRewriter) RewriteFetchResponse(w io.Writer, r io.Reader) error {
+	pr := NewReader(r)
+	pw := NewWriter(w)
+
+	var wantedRefs bool
+	for {
+		p, err := pr.ReadPacket()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if p.Kind != Data {
+			// sections are separated by delimiters
+			wantedRefs = false
+		} else if wantedRefs {
+			// each line is `<oid> <ref>`
+			if oid, ref, found := bytes.Cut(bytes.TrimSuffix(p.Payload, []byte("\n")), []byte(" ")); found {
+				if name, ok := rw.unwant(string(ref)); ok {
+					p.Payload = []byte(string(oid) + " " + name + "\n")
+				}
+			}
+		}
+
+		if err := pw.WritePacket(p); err != nil {
+			return err
+		}
+
+		if p.Kind != Data {
+			continue
+		}
+
+		switch string(p.Payload) {
+		case "wanted-refs\n":
+			wantedRefs = true
+		case "packfile\n":
+			_, err := io.Copy(w, pr.Remaining())
+			return err
+		}
+	}
+}
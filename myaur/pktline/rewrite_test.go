@@ -0,0 +1,350 @@
+package pktline
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+const (
+	oidA = "1111111111111111111111111111111111111111"
+	oidB = "2222222222222222222222222222222222222222"
+	oidC = "3333333333333333333333333333333333333333"
+)
+
+// the refs a package's repo appears to have, and where they live in the mirror. oidA and oidB are the package's
+// objects, and oidC belongs to some other package
+func testRewriter() *RefRewriter {
+	resolve := MapResolver(map[string]string{
+		"HEAD":              "refs/heads/yay",
+		"refs/heads/master": "refs/heads/yay",
+		"refs/tags/v1":      "refs/tags/v1",
+	})
+	allow := func(hash string) (bool, error) {
+		return hash == oidA || hash == oidB, nil
+	}
+	return NewRefRewriter(resolve, allow)
+}
+
+func rewriteRequest(t *testing.T, rw *RefRewriter, input string) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := rw.RewriteRequest(&buf, strings.NewReader(input)); err != nil {
+		t.Fatalf("failed to rewrite request: %v", err)
+	}
+	return buf.String()
+}
+
+// requests that don't ask for anything by name have to come out exactly as they went in
+func TestRewriteRequestUnchanged(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{
+			name: "v0 multi_ack",
+			input: encode(
+				"want "+oidA+" multi_ack_detailed side-band-64k thin-pack ofs-delta agent=git/2.39.5\n",
+				"want "+oidB+"\n",
+				"0000",
+				"have "+oidC+"\n",
+				"done\n",
+			),
+		},
+		{
+			name: "v0 shallow",
+			input: encode(
+				"want "+oidA+" multi_ack shallow\n",
+				"shallow "+oidB+"\n",
+				"deepen 1\n",
+				"0000",
+				"done\n",
+			),
+		},
+		{
+			name: "v0 deepen",
+			input: encode(
+				"want "+oidA+" shallow deepen-since deepen-not\n",
+				"deepen-since 1700000000\n",
+				"0000",
+			),
+		},
+		{
+			// a have is just an object id, even when it looks like a ref name
+			name: "v0 haves",
+			input: encode(
+				"want "+oidA+"\n",
+				"0000",
+				"have "+oidB+"\n",
+				"have refs/heads/master\n",
+				"0000",
+			),
+		},
+		{
+			name: "v2 ls-refs",
+			input: encode(
+				"command=ls-refs\n",
+				"agent=git/2.39.5\n",
+				"0001",
+				"peel\n",
+				"symrefs\n",
+				"ref-prefix HEAD\n",
+				"ref-prefix refs/heads/\n",
+				"ref-prefix refs/tags/\n",
+				"0000",
+			),
+		},
+		{
+			name: "v2 fetch by oid",
+			input: encode(
+				"command=fetch\n",
+				"object-format=sha1\n",
+				"0001",
+				"thin-pack\n",
+				"deepen 1\n",
+				"want "+oidA+"\n",
+				"have "+oidB+"\n",
+				"done\n",
+				"0000",
+			),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rewriteRequest(t, testRewriter(), tt.input); got != tt.input {
+				t.Errorf("request changed\ngot:  %q\nwant: %q", got, tt.input)
+			}
+		})
+	}
+}
+
+func TestRewriteRequestWantRef(t *testing.T) {
+	input := encode(
+		"command=fetch\n",
+		"0001",
+		"deepen 1\n",
+		"want-ref HEAD\n",
+		"want-ref refs/heads/master\n",
+		"want-ref refs/tags/v1\n",
+		"done\n",
+		"0000",
+	)
+	want := encode(
+		"command=fetch\n",
+		"0001",
+		"deepen 1\n",
+		"want-ref refs/heads/yay\n",
+		"want-ref refs/heads/yay\n",
+		"want-ref refs/tags/v1\n",
+		"done\n",
+		"0000",
+	)
+
+	if got := rewriteRequest(t, testRewriter(), input); got != want {
+		t.Errorf("got:  %q\nwant: %q", got, want)
+	}
+}
+
+// --shallow-exclude sends the ref as it was given, which upload-pack expands the same way rev-parse would
+func TestRewriteRequestDeepenNot(t *testing.T) {
+	tests := []struct {
+		name string
+		ref  string
+		want string
+	}{
+		{name: "full name", ref: "refs/heads/master", want: "refs/heads/yay"},
+		{name: "branch", ref: "master", want: "refs/heads/yay"},
+		{name: "tag", ref: "v1", want: "refs/tags/v1"},
+		{name: "head", ref: "HEAD", want: "refs/heads/yay"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := encode(
+				"want "+oidA+" shallow deepen-not\n",
+				"deepen-not "+tt.ref+"\n",
+				"0000",
+			)
+			want := encode(
+				"want "+oidA+" shallow deepen-not\n",
+				"deepen-not "+tt.want+"\n",
+				"0000",
+			)
+
+			if got := rewriteRequest(t, testRewriter(), input); got != want {
+				t.Errorf("got:  %q\nwant: %q", got, want)
+			}
+		})
+	}
+
+	t.Run("unknown", func(t *testing.T) {
+		input := encode(
+			"command=fetch\n",
+			"0001",
+			"want "+oidA+"\n",
+			"deepen-not libfoo\n",
+			"done\n",
+			"0000",
+		)
+
+		var buf bytes.Buffer
+		err := testRewriter().RewriteRequest(&buf, strings.NewReader(input))
+
+		var unknown *UnknownRefError
+		if !errors.As(err, &unknown) || unknown.Name != "libfoo" {
+			t.Fatalf("got %v, want an UnknownRefError for libfoo", err)
+		}
+		if strings.Contains(buf.String(), "libfoo") {
+			t.Errorf("the unknown ref was passed on: %q", buf.String())
+		}
+	})
+}
+
+func TestRewriteRequestUnknownRef(t *testing.T) {
+	input := encode(
+		"command=fetch\n",
+		"0001",
+		"want-ref refs/heads/libfoo\n",
+		"done\n",
+		"0000",
+	)
+
+	var buf bytes.Buffer
+	err := testRewriter().RewriteRequest(&buf, strings.NewReader(input))
+
+	var unknown *UnknownRefError
+	if !errors.As(err, &unknown) {
+		t.Fatalf("got %v, want an UnknownRefError", err)
+	}
+	if unknown.Name != "refs/heads/libfoo" {
+		t.Errorf("got unknown ref %q, want refs/heads/libfoo", unknown.Name)
+	}
+	if strings.Contains(buf.String(), "libfoo") {
+		t.Errorf("the unknown ref was passed on: %q", buf.String())
+	}
+}
+
+// wanting an object by id is only allowed for the package's own objects, in both protocols
+func TestRewriteRequestUnknownObject(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{
+			name: "v0",
+			input: encode(
+				"want "+oidC+" multi_ack_detailed side-band-64k ofs-delta\n",
+				"0000",
+				"done\n",
+			),
+		},
+		{
+			name: "v0 second want",
+			input: encode(
+				"want "+oidA+" multi_ack_detailed side-band-64k ofs-delta\n",
+				"want "+oidC+"\n",
+				"0000",
+				"done\n",
+			),
+		},
+		{
+			name: "v2",
+			input: encode(
+				"command=fetch\n",
+				"0001",
+				"want "+oidC+"\n",
+				"done\n",
+				"0000",
+			),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := testRewriter().RewriteRequest(&buf, strings.NewReader(tt.input))
+
+			var unknown *UnknownObjectError
+			if !errors.As(err, &unknown) {
+				t.Fatalf("got %v, want an UnknownObjectError", err)
+			}
+			if unknown.Hash != oidC {
+				t.Errorf("got unknown object %s, want %s", unknown.Hash, oidC)
+			}
+			if strings.Contains(buf.String(), oidC) {
+				t.Errorf("the unknown object was passed on: %q", buf.String())
+			}
+		})
+	}
+}
+
+func TestRewriteFetchResponse(t *testing.T) {
+	rw := testRewriter()
+
+	// the client has to ask for the refs first, so the rewriter knows what to call them in the response
+	rewriteRequest(t, rw, encode(
+		"command=fetch\n",
+		"0001",
+		"want-ref HEAD\n",
+		"want-ref refs/heads/master\n",
+		"want-ref refs/tags/v1\n",
+		"done\n",
+		"0000",
+	))
+
+	// the pack data contains a pkt-line that mentions the branch, which must be left alone
+	pack := "PACK" + encode(oidA+" refs/heads/yay\n")
+
+	input := encode(
+		"shallow-info\n",
+		"shallow "+oidA+"\n",
+		"0001",
+		"wanted-refs\n",
+		oidA+" refs/heads/yay\n",
+		oidA+" refs/heads/yay\n",
+		oidB+" refs/tags/v1\n",
+		"0001",
+		"packfile\n",
+	) + pack
+	want := encode(
+		"shallow-info\n",
+		"shallow "+oidA+"\n",
+		"0001",
+		"wanted-refs\n",
+		oidA+" HEAD\n",
+		oidA+" refs/heads/master\n",
+		oidB+" refs/tags/v1\n",
+		"0001",
+		"packfile\n",
+	) + pack
+
+	var buf bytes.Buffer
+	if err := rw.RewriteFetchResponse(&buf, strings.NewReader(input)); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != want {
+		t.Errorf("got:  %q\nwant: %q", got, want)
+	}
+}
+
+// acknowledgments and everything else outside of wanted-refs never changes
+func TestRewriteFetchResponseAcks(t *testing.T) {
+	input := encode(
+		"acknowledgments\n",
+		"ACK "+oidA+"\n",
+		"ready\n",
+		"0001",
+		"packfile\n",
+	) + "PACK"
+
+	var buf bytes.Buffer
+	if err := testRewriter().RewriteFetchResponse(&buf, strings.NewReader(input)); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != input {
+		t.Errorf("response changed\ngot:  %q\nwant: %q", got, input)
+	}
+}
@@ -0,0 +1,124 @@
+package populate
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/haileyok/myaur/myaur/database"
+	"github.com/haileyok/myaur/myaur/gitrepo"
+)
+
+// only people who have pushed to a package within this long of its last push are counted as co-maintainers, so that
+// previous maintainers of packages that changed hands don't show up forever
+const coMaintainerWindow = 365 * 24 * time.Hour
+
+// loadUsernames reads a file mapping commit emails to aur usernames, one `email = username` per line. blank lines
+// and lines starting with `#` are ignored
+func loadUsernames(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open maintainer overrides: %w", err)
+	}
+	defer f.Close()
+
+	usernames := map[string]string{}
+
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		email, username, found := strings.Cut(line, "=")
+		email = strings.ToLower(strings.TrimSpace(email))
+		username = strings.TrimSpace(username)
+		if !found || email == "" || username == "" {
+			return nil, fmt.Errorf("invalid maintainer override on line %d of %s", n, path)
+		}
+
+		usernames[email] = username
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read maintainer overrides: %w", err)
+	}
+
+	return usernames, nil
+}
+
+// username returns the aur username for the author of a commit. the overrides are checked first, and otherwise
+// the name the commit was authored with is used
+func (p *Populate) username(sig gitrepo.Signature) string {
+	if username, ok := p.usernames[strings.ToLower(sig.Email)]; ok {
+		return username
+	}
+	if sig.Name != "" {
+		return sig.Name
+	}
+	name, _, _ := strings.Cut(sig.Email, "@")
+	return name
+}
+
+// maintainers works out who maintains a package from the recent history of its branch, which is everything within
+// coMaintainerWindow of the latest commit, newest first. the author of the latest commit is the maintainer, and
+// anyone else in the recent history is a co-maintainer
+func (p *Populate) maintainers(history []gitrepo.Commit) (maintainer string, coMaintainers []string) {
+	maintainer = p.username(history[0].Author)
+
+	coMaintainers = []string{}
+	seen := map[string]bool{maintainer: true}
+	for _, c := range history[1:] {
+		name := p.username(c.Author)
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		coMaintainers = append(coMaintainers, name)
+	}
+
+	return maintainer, coMaintainers
+}
+
+// submissions returns when each branch was first submitted and who submitted it, which comes from the commit the
+// branch was created with. that never changes, so branches that already have a submission in the database keep it,
+// and the rest get their first commits looked up all at once. a full run looks all of them up again, in case the
+// username overrides changed
+func (p *Populate) submissions(branches []string) (map[string]database.Submission, error) {
+	stored := map[string]database.Submission{}
+	if !p.full {
+		var err error
+		stored, err = p.db.GetSubmissions()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get stored submissions: %w", err)
+		}
+	}
+
+	submissions := make(map[string]database.Submission, len(branches))
+	var missing []string
+	for _, branch := range branches {
+		if s, ok := stored[branch]; ok {
+			submissions[branch] = s
+			continue
+		}
+		missing = append(missing, branch)
+	}
+
+	roots, err := p.repo.GetRootCommits(missing)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get first commits: %w", err)
+	}
+	for branch, root := range roots {
+		submissions[branch] = database.Submission{
+			FirstSubmitted: root.Committer.When.Unix(),
+			Submitter:      p.username(root.Author),
+		}
+	}
+
+	p.logger.Info("found first commits", "stored", len(branches)-len(missing), "looked-up", len(missing))
+
+	return submissions, nil
+}
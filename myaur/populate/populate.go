@@ -4,29 +4,38 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"maps"
 	"os"
+	"slices"
 	"sync"
 	"sync/atomic"
 
 	"github.com/haileyok/myaur/myaur/database"
 	"github.com/haileyok/myaur/myaur/gitrepo"
 	"github.com/haileyok/myaur/myaur/srcinfo"
-	"golang.org/x/sync/semaphore"
 )
 
 type Populate struct {
-	logger *slog.Logger
-	repo   *gitrepo.Repo
-	db     *database.Database
-	sem    *semaphore.Weighted
+	logger      *slog.Logger
+	repo        gitrepo.Repo
+	db          *database.Database
+	concurrency int
+	full        bool
+	// aur usernames for commit author emails, for authors whose commit name isn't their aur username
+	usernames map[string]string
 }
 
 type Args struct {
 	DatabasePath  string
 	RepoPath      string
 	RemoteRepoUrl string
+	GitBackend    string
 	Debug         bool
 	Concurrency   int
+	// reprocess every branch, even ones whose commit has not changed since the last run
+	Full bool
+	// path to a file mapping commit author emails to aur usernames, one `email = username` per line
+	MaintainerOverrides string
 }
 
 func New(args *Args) (*Populate, error) {
@@ -49,6 +58,7 @@ func New(args *Args) (*Populate, error) {
 	repo, err := gitrepo.New(&gitrepo.Args{
 		RepoPath:   args.RepoPath,
 		AurRepoUrl: args.RemoteRepoUrl,
+		Backend:    args.GitBackend,
 		Debug:      args.Debug,
 	})
 	if err != nil {
@@ -63,13 +73,21 @@ func New(args *Args) (*Populate, error) {
 		return nil, fmt.Errorf("failed to create database client: %w", err)
 	}
 
-	sem := semaphore.NewWeighted(int64(args.Concurrency))
+	usernames := map[string]string{}
+	if args.MaintainerOverrides != "" {
+		usernames, err = loadUsernames(args.MaintainerOverrides)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	return &Populate{
-		logger: logger,
-		repo:   repo,
-		db:     db,
-		sem:    sem,
+		logger:      logger,
+		repo:        repo,
+		db:          db,
+		concurrency: args.Concurrency,
+		full:        args.Full,
+		usernames:   usernames,
 	}, nil
 }
 
@@ -81,80 +99,253 @@ func (p *Populate) Run(ctx context.Context) error {
 		return fmt.Errorf("failed to ensure repository: %w", err)
 	}
 
-	// get all the branches that exist
-	branches, err := p.repo.ListBranches()
+	// get all the branches that exist, along with the commit they point at
+	heads, err := p.repo.ListBranchHeads()
 	if err != nil {
 		return fmt.Errorf("failed to list branches: %w", err)
 	}
 
-	p.logger.Info("processing branches", "total", len(branches))
+	// only the branches that have moved since the last time they were processed need to be parsed again
+	processed, err := p.db.GetBranchCommits()
+	if err != nil {
+		return fmt.Errorf("failed to get processed branch commits: %w", err)
+	}
+
+	var branches []string
+	for branch, commit := range heads {
+		if !p.full && processed[branch] == commit {
+			continue
+		}
+		branches = append(branches, branch)
+	}
+	slices.Sort(branches)
+
+	p.logger.Info("processing branches", "changed", len(branches), "unchanged", len(heads)-len(branches), "total", len(heads))
+
+	submissions, err := p.submissions(branches)
+	if err != nil {
+		return err
+	}
+
+	// the whole run happens inside of one transaction, so the server keeps reading the previous snapshot until
+	// everything from this fetch is in place. otherwise a client could see the new version of one split package
+	// alongside the old version of another
+	return p.db.Transaction(func(tx *database.Database) error {
+		// anything whose branch is gone from the mirror has been deleted upstream, so it needs to be removed from
+		// the database too. otherwise info and search keep returning packages that can't be cloned anymore
+		removed, err := p.removeDeletedBases(tx, heads)
+		if err != nil {
+			return fmt.Errorf("failed to remove deleted packages: %w", err)
+		}
+
+		return p.processBranches(ctx, tx, branches, heads, submissions, removed)
+	})
+}
+
+func (p *Populate) removeDeletedBases(tx *database.Database, heads map[string]string) (int64, error) {
+	bases, err := tx.GetPackageBases()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get package bases: %w", err)
+	}
+
+	deleted := map[string]struct{}{}
+	for _, base := range bases {
+		if _, ok := heads[base]; !ok {
+			deleted[base] = struct{}{}
+		}
+	}
+
+	// branches that failed to ever parse won't have any packages, but may still have a commit recorded
+	commits, err := tx.GetBranchCommits()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get processed branch commits: %w", err)
+	}
+	for branch := range commits {
+		if _, ok := heads[branch]; !ok {
+			deleted[branch] = struct{}{}
+		}
+	}
+
+	if len(deleted) == 0 {
+		return 0, nil
+	}
+
+	removed, err := tx.DeletePackageBases(slices.Collect(maps.Keys(deleted)))
+	if err != nil {
+		return 0, err
+	}
+
+	p.logger.Info("removed packages deleted upstream", "bases", len(deleted), "packages", removed)
 
-	return p.processBranches(ctx, branches)
+	return removed, nil
 }
 
-func (p *Populate) processBranches(ctx context.Context, branches []string) error {
+// the number of branches that get written to the database in a single transaction
+const writeBatchSize = 250
+
+// processBranches parses every branch and writes the results with the given transaction. the transaction is only
+// ever used from a single writer goroutine
+func (p *Populate) processBranches(ctx context.Context, tx *database.Database, branches []string, heads map[string]string, submissions map[string]database.Submission, removedBases int64) error {
 	var wg sync.WaitGroup
 
-	var processed, succeeded, failed atomic.Int64
+	var processed, succeeded, failed, removed atomic.Int64
+	removed.Store(removedBases)
 
 	logger := p.logger.With("component", "branch-processor")
 
-	for _, b := range branches {
-		if err := p.sem.Acquire(ctx, 1); err != nil {
-			logger.Error("failed to acuiqre semaphore", "err", err)
-			continue
+	work := make(chan string)
+	updates := make(chan database.BranchUpdate, writeBatchSize)
+
+	logProgress := func() {
+		processed := processed.Add(1)
+		if processed%500 == 0 {
+			logger.Info("progress", "processed", processed, "succeeded", succeeded.Load(), "failed", failed.Load(), "total", len(branches))
 		}
+		logger.Debug("progress", "processed", processed, "succeeded", succeeded.Load(), "failed", failed.Load(), "total", len(branches))
+	}
 
-		wg.Add(1)
-		go func() {
-			defer func() {
-				wg.Done()
-				p.sem.Release(1)
-			}()
+	// all of the writes go through a single goroutine in batches, so that sqlite only ever sees one writer and
+	// doesn't have to commit a transaction for every package
+	writerDone := make(chan struct{})
+	go func() {
+		defer close(writerDone)
+
+		batch := make([]database.BranchUpdate, 0, writeBatchSize)
+		flush := func() {
+			if len(batch) == 0 {
+				return
+			}
 
-			if err := p.processBranch(b); err != nil {
-				logger.Error("failed to process branch", "branch", b, "err", err)
-				failed.Add(1)
+			n, err := tx.ApplyBranchUpdates(batch)
+			if err != nil {
+				logger.Error("failed to write batch", "branches", len(batch), "err", err)
+				failed.Add(int64(len(batch)))
 			} else {
-				succeeded.Add(1)
+				succeeded.Add(int64(len(batch)))
+				removed.Add(n)
 			}
-			processed.Add(1)
 
-			processed := processed.Load()
-			if processed%500 == 0 {
-				logger.Info("progress", "processed", processed, "succeeded", succeeded.Load(), "failed", failed.Load(), "total", len(branches))
+			for range batch {
+				logProgress()
+			}
+			batch = batch[:0]
+		}
+
+		for u := range updates {
+			batch = append(batch, u)
+			if len(batch) >= writeBatchSize {
+				flush()
+			}
+		}
+		flush()
+	}()
+
+	for range min(p.concurrency, max(len(branches), 1)) {
+		// each worker streams every .SRCINFO it needs through a single cat-file process, rather than starting a new
+		// git process for each branch
+		blobs, err := p.repo.NewBlobReader()
+		if err != nil {
+			close(work)
+			wg.Wait()
+			close(updates)
+			<-writerDone
+			return fmt.Errorf("failed to create blob reader: %w", err)
+		}
+
+		wg.Go(func() {
+			defer func() {
+				if err := blobs.Close(); err != nil {
+					logger.Error("failed to close blob reader", "err", err)
+				}
+			}()
+
+			for b := range work {
+				u, err := p.processBranch(blobs, b, heads[b], submissions[b])
+				if err != nil {
+					logger.Error("failed to process branch", "branch", b, "err", err)
+					failed.Add(1)
+					logProgress()
+					continue
+				}
+				updates <- *u
 			}
-			logger.Debug("progress", "processed", processed, "succeeded", succeeded.Load(), "failed", failed.Load(), "total", len(branches))
-		}()
+		})
 	}
 
+	for _, b := range branches {
+		if ctx.Err() != nil {
+			break
+		}
+		work <- b
+	}
+	close(work)
+
 	wg.Wait()
+	close(updates)
+	<-writerDone
+
+	// a cancelled run would only be a partial snapshot, so it gets rolled back rather than committed
+	if err := ctx.Err(); err != nil {
+		logger.Error("stopping early, context cancelled", "err", err)
+		return fmt.Errorf("populate cancelled: %w", err)
+	}
 
-	logger.Info("database populated successfully", "processed", processed.Load(), "succeeded", succeeded.Load(), "failed", failed.Load())
+	logger.Info("database populated successfully", "processed", processed.Load(), "succeeded", succeeded.Load(), "failed", failed.Load(), "removed", removed.Load())
 
 	return nil
 }
 
-func (p *Populate) processBranch(branch string) error {
-	content, err := p.repo.GetFileContent(branch, ".SRCINFO")
+// processBranch parses every package in the branch, returning them to be written to the database
+func (p *Populate) processBranch(blobs gitrepo.BlobReader, branch, commit string, submission database.Submission) (*database.BranchUpdate, error) {
+	content, err := blobs.GetFileContent(branch, ".SRCINFO")
 	if err != nil {
-		return fmt.Errorf("failed to get .SRCINFO: %w", err)
+		return nil, fmt.Errorf("failed to get .SRCINFO: %w", err)
 	}
 
-	pkg, err := srcinfo.Parse(content)
+	pkgs, err := srcinfo.Parse(content)
 	if err != nil {
-		return fmt.Errorf("failed to parse .SRCINFO: %w", err)
+		return nil, fmt.Errorf("failed to parse .SRCINFO: %w", err)
 	}
 
-	if pkg.PackageBase == "" {
-		pkg.PackageBase = branch
+	// the branch is created when the package is first submitted, and every push to the aur is a new commit on it.
+	// only the pushes recent enough to count towards co-maintainers are read, rather than the whole history
+	history, err := blobs.GetRecentHistory(branch, coMaintainerWindow)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get history: %w", err)
 	}
-
-	if err := p.db.UpsertPackage(pkg); err != nil {
-		return fmt.Errorf("failed to upsert package: %w", err)
+	if len(history) == 0 {
+		return nil, fmt.Errorf("branch has no commits")
+	}
+	lastModified := history[0].Committer.When.Unix()
+	maintainer, coMaintainers := p.maintainers(history)
+
+	// the commit gets recorded in the same transaction as the packages, so a branch that fails to write gets
+	// retried on the next run
+	update := database.BranchUpdate{
+		Branch: branch,
+		Commit: commit,
 	}
 
-	p.logger.Debug("processed package", "name", pkg.Name, "version", pkg.Version)
+	// split packages will have multiple packages in a single .SRCINFO, each of which gets its own row under
+	// the same package base
+	for _, pkg := range pkgs {
+		if pkg.PackageBase == "" {
+			pkg.PackageBase = branch
+		}
 
-	return nil
+		pkg.FirstSubmitted = submission.FirstSubmitted
+		pkg.LastModified = lastModified
+		pkg.Maintainer = maintainer
+		pkg.CoMaintainers = coMaintainers
+		pkg.Submitter = submission.Submitter
+
+		update.Packages = append(update.Packages, database.PackageUpdate{
+			Package:   *pkg,
+			Relations: srcinfo.Relations(pkg),
+		})
+
+		p.logger.Debug("processed package", "name", pkg.Name, "base", pkg.PackageBase, "version", pkg.Version)
+	}
+
+	return &update, nil
 }
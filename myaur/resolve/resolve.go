@@ -0,0 +1,309 @@
+package resolve
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/haileyok/myaur/myaur/database"
+	"github.com/haileyok/myaur/myaur/srcinfo"
+	"github.com/haileyok/myaur/myaur/vercmp"
+)
+
+type Resolver struct {
+	db *database.Database
+}
+
+func New(db *database.Database) *Resolver {
+	return &Resolver{
+		db: db,
+	}
+}
+
+// Build is a single package base that needs to be built, along with the packages from it that are needed
+type Build struct {
+	PackageBase string   `json:"packagebase"`
+	Packages    []string `json:"packages"`
+}
+
+type Options struct {
+	// if not empty, the architecture specific dependencies for this arch are followed too
+	Arch string
+	// build whatever aur package provides a dependency that no aur package is named after. most of these are in the
+	// official repos and only provided by alternatives in the aur (i.e. git-git for git), so it's off by default
+	FollowProvides bool
+}
+
+type Result struct {
+	// package bases in the order they need to be built, with dependencies coming before the packages that need them
+	Order []Build `json:"order"`
+	// dependencies that could not be found in the aur. usually these are in the official repos
+	Missing []string `json:"missing"`
+	// dependencies that no aur package is named after, but that some aur packages provide, along with the packages
+	// that provide them. usually these are in the official repos too. the first provider is only built when
+	// following provides
+	Providers map[string][]string `json:"providers"`
+	// dependencies that were found in the aur, but whose version does not satisfy the constraint on them, i.e.
+	// `foo>=2.0` when the aur only has `foo` 1.0. these are still included in the build order
+	Unsatisfied []string `json:"unsatisfied"`
+	// any dependency cycles between package bases. the order is still returned, but the cycle is broken arbitrarily
+	Cycles [][]string `json:"cycles"`
+}
+
+// need is a single dependency of a package base
+type need struct {
+	raw      string
+	name     string
+	operator string
+	version  string
+}
+
+// base is a package base in the dependency graph
+type base struct {
+	packages map[string]struct{}
+	deps     map[string]struct{}
+}
+
+type resolveState struct {
+	opts      Options
+	found     map[string]*database.PackageInfo
+	bases     map[string]*base
+	missing   map[string]struct{}
+	providers map[string][]string
+	visited   map[string]struct{}
+	needs     map[string][]need
+	resolver  *Resolver
+}
+
+// Resolve finds every aur package that needs to be built in order to build the given packages, following their
+// depends, makedepends and checkdepends, and returns them in build order. dependencies are matched against package
+// names, and only against anything that provides them when opts.FollowProvides is set.
+func (r *Resolver) Resolve(names []string, opts Options) (*Result, error) {
+	st := &resolveState{
+		opts:      opts,
+		found:     map[string]*database.PackageInfo{},
+		bases:     map[string]*base{},
+		missing:   map[string]struct{}{},
+		providers: map[string][]string{},
+		visited:   map[string]struct{}{},
+		needs:     map[string][]need{},
+		resolver:  r,
+	}
+
+	queue := slices.Clone(names)
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+
+		pkg, err := st.find(name)
+		if err != nil {
+			return nil, err
+		}
+		if pkg == nil {
+			continue
+		}
+
+		b, ok := st.bases[pkg.PackageBase]
+		if !ok {
+			b = &base{
+				packages: map[string]struct{}{},
+				deps:     map[string]struct{}{},
+			}
+			st.bases[pkg.PackageBase] = b
+		}
+		b.packages[pkg.Name] = struct{}{}
+
+		// multiple names can resolve to the same package through provides, so only walk each package once
+		if _, ok := st.visited[pkg.Name]; ok {
+			continue
+		}
+		st.visited[pkg.Name] = struct{}{}
+
+		for _, dep := range slices.Concat(pkg.Depends, pkg.MakeDepends, pkg.CheckDepends) {
+			name, op, ver := srcinfo.ParseRelation(dep)
+			if name == "" {
+				continue
+			}
+			st.needs[pkg.PackageBase] = append(st.needs[pkg.PackageBase], need{
+				raw:      dep,
+				name:     name,
+				operator: op,
+				version:  ver,
+			})
+			queue = append(queue, name)
+		}
+	}
+
+	// now that every name has been looked up, the dependencies between bases can be filled in
+	unsatisfied := map[string]struct{}{}
+	for baseName, deps := range st.needs {
+		for _, dep := range deps {
+			pkg := st.found[dep.name]
+			if pkg == nil {
+				continue
+			}
+			if !satisfies(pkg, dep) {
+				unsatisfied[dep.raw] = struct{}{}
+			}
+			if pkg.PackageBase == baseName {
+				continue
+			}
+			st.bases[baseName].deps[pkg.PackageBase] = struct{}{}
+		}
+	}
+
+	order, cycles := st.sort()
+
+	missing := make([]string, 0, len(st.missing))
+	for name := range st.missing {
+		missing = append(missing, name)
+	}
+	slices.Sort(missing)
+
+	return &Result{
+		Order:       order,
+		Missing:     missing,
+		Providers:   st.providers,
+		Unsatisfied: sortedKeys(unsatisfied),
+		Cycles:      cycles,
+	}, nil
+}
+
+// satisfies reports whether the package satisfies the dependency, either by its own version or by the version of
+// something it provides. like pacman, an unversioned provides never satisfies a versioned dependency
+func satisfies(pkg *database.PackageInfo, dep need) bool {
+	if pkg.Name == dep.name {
+		return vercmp.Satisfies(pkg.Version, dep.operator, dep.version)
+	}
+
+	for _, p := range pkg.Provides {
+		name, op, ver := srcinfo.ParseRelation(p)
+		if name != dep.name {
+			continue
+		}
+		if dep.operator == "" {
+			return true
+		}
+		if op == "=" && vercmp.Satisfies(ver, dep.operator, dep.version) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// find looks up the package that satisfies the given name, either by its name or, when following provides, by
+// something that provides it. nil is returned if nothing in the aur gets built for it
+func (st *resolveState) find(name string) (*database.PackageInfo, error) {
+	if pkg, ok := st.found[name]; ok {
+		return pkg, nil
+	}
+
+	pkg, providers, err := st.resolver.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if pkg == nil && len(providers) > 0 {
+		for _, p := range providers {
+			st.providers[name] = append(st.providers[name], p.Name)
+		}
+		// if more than one package provides the name, pick the first one by name so the result is at least stable
+		if st.opts.FollowProvides {
+			pkg = &providers[0]
+		}
+	} else if pkg == nil {
+		st.missing[name] = struct{}{}
+	}
+
+	if pkg != nil && st.opts.Arch != "" {
+		pkg.MergeArch(st.opts.Arch)
+	}
+
+	st.found[name] = pkg
+	return pkg, nil
+}
+
+// lookup finds the package named name. if there isn't one, every package that provides name is returned instead,
+// sorted by name
+func (r *Resolver) lookup(name string) (*database.PackageInfo, []database.PackageInfo, error) {
+	// use the list lookup rather than GetPackageByName, since most dependencies are in the official repos and
+	// aren't going to be found. gorm logs every record not found error otherwise
+	pkgs, err := r.db.GetPackagesByNames([]string{name})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to lookup package %s: %w", name, err)
+	}
+	if len(pkgs) > 0 {
+		return &pkgs[0], nil, nil
+	}
+
+	providers, err := r.db.GetPackagesByRelation(database.RelationProvides, name)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to lookup providers for %s: %w", name, err)
+	}
+
+	slices.SortFunc(providers, func(a, b database.PackageInfo) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return nil, providers, nil
+}
+
+// sort does a depth first topological sort of the package bases, returning them with dependencies first along with
+// any cycles that were found
+func (st *resolveState) sort() ([]Build, [][]string) {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+
+	state := map[string]int{}
+	// these are never nil, so they serialise as empty lists rather than null like the rest of the result
+	order := []Build{}
+	cycles := [][]string{}
+	var stack []string
+
+	var visit func(name string)
+	visit = func(name string) {
+		switch state[name] {
+		case done:
+			return
+		case visiting:
+			// everything on the stack from the first time we saw this base is part of the cycle
+			idx := slices.Index(stack, name)
+			cycles = append(cycles, append(slices.Clone(stack[idx:]), name))
+			return
+		}
+
+		state[name] = visiting
+		stack = append(stack, name)
+
+		for _, dep := range sortedKeys(st.bases[name].deps) {
+			visit(dep)
+		}
+
+		stack = stack[:len(stack)-1]
+		state[name] = done
+
+		order = append(order, Build{
+			PackageBase: name,
+			Packages:    sortedKeys(st.bases[name].packages),
+		})
+	}
+
+	for _, name := range sortedKeys(st.bases) {
+		visit(name)
+	}
+
+	return order, cycles
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,96 @@
+package resolve
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/haileyok/myaur/myaur/database"
+	"github.com/haileyok/myaur/myaur/srcinfo"
+)
+
+// newTestResolver creates a resolver over a database holding the given packages, each in its own package base
+func newTestResolver(t *testing.T, pkgs ...database.PackageInfo) *Resolver {
+	t.Helper()
+
+	db, err := database.New(&database.Args{
+		DatabasePath: filepath.Join(t.TempDir(), "myaur.db"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var updates []database.BranchUpdate
+	for _, pkg := range pkgs {
+		pkg.PackageBase = pkg.Name
+		updates = append(updates, database.BranchUpdate{
+			Branch:   pkg.Name,
+			Commit:   pkg.Name,
+			Packages: []database.PackageUpdate{{Package: pkg, Relations: srcinfo.Relations(&pkg)}},
+		})
+	}
+
+	if _, err := db.ApplyBranchUpdates(updates); err != nil {
+		t.Fatal(err)
+	}
+
+	return New(db)
+}
+
+// git is in the official repos, so the aur only has git-git, which provides it. that must not end up being built
+// just because something depends on git
+func TestResolveProviders(t *testing.T) {
+	r := newTestResolver(t,
+		database.PackageInfo{Name: "app", Version: "1.0-1", Depends: []string{"git", "libfoo>=1.0", "glibc"}},
+		database.PackageInfo{Name: "libfoo", Version: "1.2-1"},
+		database.PackageInfo{Name: "git-git", Version: "2.50.0.r1.gabc-1", Provides: []string{"git"}},
+		database.PackageInfo{Name: "git-vfs", Version: "2.49.0-1", Provides: []string{"git=2.49.0"}},
+	)
+
+	tests := []struct {
+		name string
+		opts Options
+		want *Result
+	}{
+		{
+			name: "default",
+			want: &Result{
+				Order: []Build{
+					{PackageBase: "libfoo", Packages: []string{"libfoo"}},
+					{PackageBase: "app", Packages: []string{"app"}},
+				},
+				Missing:     []string{"glibc"},
+				Providers:   map[string][]string{"git": {"git-git", "git-vfs"}},
+				Unsatisfied: []string{},
+				Cycles:      [][]string{},
+			},
+		},
+		{
+			name: "follow provides",
+			opts: Options{FollowProvides: true},
+			want: &Result{
+				Order: []Build{
+					{PackageBase: "git-git", Packages: []string{"git-git"}},
+					{PackageBase: "libfoo", Packages: []string{"libfoo"}},
+					{PackageBase: "app", Packages: []string{"app"}},
+				},
+				Missing:     []string{"glibc"},
+				Providers:   map[string][]string{"git": {"git-git", "git-vfs"}},
+				Unsatisfied: []string{},
+				Cycles:      [][]string{},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := r.Resolve([]string{"app"}, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got  %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package resolve
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/haileyok/myaur/myaur/database"
+)
+
+// the relation kinds that are followed when looking for dependents
+var reverseKinds = []string{
+	database.RelationDepends,
+	database.RelationMakeDepends,
+	database.RelationOptDepends,
+	database.RelationCheckDepends,
+}
+
+// Dependent is a package that depends on the looked up package, either directly or through other packages
+type Dependent struct {
+	Name string `json:"name"`
+	// the package this one depends on. for direct dependents this is the package that was looked up
+	Via string `json:"via"`
+	// how many hops away from the looked up package this one is. direct dependents have a depth of one
+	Depth int `json:"depth"`
+}
+
+type ReverseResult struct {
+	Name         string      `json:"name"`
+	Depends      []Dependent `json:"depends"`
+	MakeDepends  []Dependent `json:"makedepends"`
+	OptDepends   []Dependent `json:"optdepends"`
+	CheckDepends []Dependent `json:"checkdepends"`
+}
+
+// ReverseDeps finds every package that depends on the given package, split up by the kind of dependency. anything
+// that depends on a name the package provides is included too. if transitive is true, the dependents of the
+// dependents are followed as well, with each package listed once per kind at the shortest depth it was found at.
+func (r *Resolver) ReverseDeps(name string, transitive bool) (*ReverseResult, error) {
+	result := &ReverseResult{
+		Name:         name,
+		Depends:      []Dependent{},
+		MakeDepends:  []Dependent{},
+		OptDepends:   []Dependent{},
+		CheckDepends: []Dependent{},
+	}
+
+	// the package itself should never show up as its own dependent
+	seen := map[string]map[string]struct{}{}
+	for _, kind := range reverseKinds {
+		seen[kind] = map[string]struct{}{name: {}}
+	}
+	walked := map[string]struct{}{name: {}}
+
+	frontier := []string{name}
+	for depth := 1; len(frontier) > 0; depth++ {
+		// anything that depends on something the frontier provides depends on the frontier too, so we need to map
+		// the provided names back to the package that provides them
+		targets := map[string]string{}
+		for _, n := range frontier {
+			targets[n] = n
+		}
+
+		provides, err := r.db.GetPackageRelations(frontier, []string{database.RelationProvides})
+		if err != nil {
+			return nil, fmt.Errorf("failed to lookup provides: %w", err)
+		}
+		for _, p := range provides {
+			if _, ok := targets[p.Name]; !ok {
+				targets[p.Name] = p.PackageName
+			}
+		}
+
+		rows, err := r.db.GetReverseRelations(sortedKeys(targets), reverseKinds)
+		if err != nil {
+			return nil, fmt.Errorf("failed to lookup reverse dependencies: %w", err)
+		}
+
+		var next []string
+		for _, row := range rows {
+			if _, ok := seen[row.Kind][row.PackageName]; ok {
+				continue
+			}
+			seen[row.Kind][row.PackageName] = struct{}{}
+
+			dep := Dependent{
+				Name:  row.PackageName,
+				Via:   targets[row.Name],
+				Depth: depth,
+			}
+
+			switch row.Kind {
+			case database.RelationDepends:
+				result.Depends = append(result.Depends, dep)
+			case database.RelationMakeDepends:
+				result.MakeDepends = append(result.MakeDepends, dep)
+			case database.RelationOptDepends:
+				result.OptDepends = append(result.OptDepends, dep)
+			case database.RelationCheckDepends:
+				result.CheckDepends = append(result.CheckDepends, dep)
+			}
+
+			if _, ok := walked[row.PackageName]; !ok {
+				walked[row.PackageName] = struct{}{}
+				next = append(next, row.PackageName)
+			}
+		}
+
+		if !transitive {
+			break
+		}
+		frontier = next
+	}
+
+	for _, deps := range [][]Dependent{result.Depends, result.MakeDepends, result.OptDepends, result.CheckDepends} {
+		slices.SortFunc(deps, func(a, b Dependent) int {
+			if a.Depth != b.Depth {
+				return a.Depth - b.Depth
+			}
+			return strings.Compare(a.Name, b.Name)
+		})
+	}
+
+	return result, nil
+}
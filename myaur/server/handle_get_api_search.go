@@ -0,0 +1,98 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/haileyok/myaur/myaur/database"
+	"github.com/labstack/echo/v4"
+)
+
+// the page size used when a search doesn't ask for one
+const defaultSearchLimit = 50
+
+type GetApiSearchInput struct {
+	By     string `query:"by"`
+	Arch   string `query:"arch"`
+	Sort   string `query:"sort"`
+	Order  string `query:"order"`
+	Limit  int    `query:"limit"`
+	Offset int    `query:"offset"`
+}
+
+type GetApiSearchOutput struct {
+	Total   int64                  `json:"total"`
+	Limit   int                    `json:"limit"`
+	Offset  int                    `json:"offset"`
+	Results []database.PackageInfo `json:"results"`
+}
+
+// handleGetApiSearch is a paginated version of the rpc search. it takes the same `by` and `arch` values, along with
+// `limit`, `offset`, `sort` (name, votes, popularity, modified or version) and `order` (asc or desc). the total
+// number of matches is returned alongside each page. i.e. /api/search/foo?by=name-desc&sort=votes&order=desc&limit=20
+func (s *Server) handleGetApiSearch(e echo.Context) error {
+	logger := s.logger.With("handler", "getApiSearch")
+
+	var input GetApiSearchInput
+	if err := e.Bind(&input); err != nil {
+		logger.Error("failed to bind request", "err", err)
+		return e.JSON(400, makeApiErrJson("Failed to bind request"))
+	}
+
+	logger = logger.With("input", input)
+
+	if input.By == "" {
+		input.By = defaultSearchBy
+	}
+	if _, ok := GetSearchInputByAllowedValues[input.By]; !ok {
+		return e.JSON(400, makeApiErrJson("Invalid `by` supplied. Valid values are name, name-desc, maintainer, depends, makedepends, optdepends, checkdepends, provides, conflicts, replaces, groups, keywords, submitter, comaintainers"))
+	}
+
+	if input.Sort != "" {
+		if _, ok := GetSearchInputSortAllowedValues[input.Sort]; !ok {
+			return e.JSON(400, makeApiErrJson("Invalid `sort` supplied. Valid values are name, votes, popularity, modified, version"))
+		}
+	}
+
+	if input.Order != "" && input.Order != "asc" && input.Order != "desc" {
+		return e.JSON(400, makeApiErrJson("Invalid `order` supplied. Valid values are asc, desc"))
+	}
+
+	if input.Limit == 0 {
+		input.Limit = min(defaultSearchLimit, s.maxResults)
+	}
+	if input.Limit < 0 || input.Limit > s.maxResults {
+		return e.JSON(400, makeApiErrJson(fmt.Sprintf("Invalid `limit` supplied. Must be between 1 and %d", s.maxResults)))
+	}
+
+	if input.Offset < 0 {
+		return e.JSON(400, makeApiErrJson("Invalid `offset` supplied. Must not be negative"))
+	}
+
+	term := e.Param("term")
+
+	total, err := s.db.CountSearchResults(input.By, term)
+	if err != nil {
+		logger.Error("failed to count search results", "err", err)
+		return e.JSON(500, makeApiErrJson("Error searching for packages"))
+	}
+
+	pkgs, err := s.db.SearchPackages(input.By, term, &database.SearchOptions{
+		Limit:  input.Limit,
+		Offset: input.Offset,
+		Sort:   input.Sort,
+		Desc:   input.Order == "desc",
+	})
+	if err != nil {
+		logger.Error("failed to search for packages", "err", err)
+		return e.JSON(500, makeApiErrJson("Error searching for packages"))
+	}
+
+	mergeArch(pkgs, input.Arch)
+
+	return e.JSON(200, GetApiSearchOutput{
+		Total:   total,
+		Limit:   input.Limit,
+		Offset:  input.Offset,
+		Results: pkgs,
+	})
+}
@@ -1,35 +1,87 @@
 package server
 
-import "github.com/labstack/echo/v4"
+import (
+	"github.com/haileyok/myaur/myaur/database"
+	"github.com/labstack/echo/v4"
+)
 
 type GetInfoInput struct {
 	Arg []string `query:"arg"`
 }
 
+// InfoResult is a package the way the aur returns it from info. License and Keywords are always there, but the
+// relations and co-maintainers are left out entirely when a package doesn't have any
+type InfoResult struct {
+	SearchResult
+	Submitter     *string  `json:"Submitter"`
+	License       []string `json:"License"`
+	Keywords      []string `json:"Keywords"`
+	Depends       []string `json:"Depends,omitempty"`
+	MakeDepends   []string `json:"MakeDepends,omitempty"`
+	CheckDepends  []string `json:"CheckDepends,omitempty"`
+	OptDepends    []string `json:"OptDepends,omitempty"`
+	Conflicts     []string `json:"Conflicts,omitempty"`
+	Provides      []string `json:"Provides,omitempty"`
+	Replaces      []string `json:"Replaces,omitempty"`
+	Groups        []string `json:"Groups,omitempty"`
+	CoMaintainers []string `json:"CoMaintainers,omitempty"`
+}
+
+func makeInfoResult(pkg *database.PackageInfo) InfoResult {
+	res := InfoResult{
+		SearchResult:  makeSearchResult(pkg),
+		Submitter:     nullString(pkg.Submitter),
+		License:       pkg.License,
+		Keywords:      pkg.Keywords,
+		Depends:       pkg.Depends,
+		MakeDepends:   pkg.MakeDepends,
+		CheckDepends:  pkg.CheckDepends,
+		OptDepends:    pkg.OptDepends,
+		Conflicts:     pkg.Conflicts,
+		Provides:      pkg.Provides,
+		Replaces:      pkg.Replaces,
+		Groups:        pkg.Groups,
+		CoMaintainers: pkg.CoMaintainers,
+	}
+	if res.License == nil {
+		res.License = []string{}
+	}
+	if res.Keywords == nil {
+		res.Keywords = []string{}
+	}
+	return res
+}
+
 func (s *Server) handleGetInfo(e echo.Context) error {
 	logger := s.logger.With("route", "getInfo")
 
-	// HACK: probably better way to do this...
-	queryParams := e.QueryParams()
-	args := queryParams["arg[]"]
-	if len(args) == 0 {
-		args = queryParams["arg"]
+	args, err := rpcArgs(e)
+	if err != nil {
+		logger.Error("failed to parse request", "err", err)
+		return rpcError(e, 200, "Failed to parse request")
 	}
 
 	if len(args) == 0 {
-		return e.JSON(400, makeErrJson("Missing `arg` parameter"))
+		return rpcError(e, 200, "No request type/data specified.")
 	}
 
 	pkgs, err := s.db.GetPackagesByNames(args)
 	if err != nil {
 		logger.Error("failed to lookup packages", "err", err)
-		return e.JSON(500, makeErrJson("Failed to search for packages"))
+		return rpcError(e, 500, "Failed to search for packages")
+	}
+
+	mergeArch(pkgs, e.FormValue("arch"))
+
+	results := make([]InfoResult, 0, len(pkgs))
+	for i := range pkgs {
+		results = append(results, makeInfoResult(&pkgs[i]))
 	}
 
-	return e.JSON(200, GetSearchOutput{
-		Version:     5,
-		Type:        "search",
-		ResultCount: len(pkgs),
-		Results:     pkgs,
+	return rpcJson(e, 200, GetSearchOutput{
+		Version:     rpcVersion(e),
+		Type:        rpcType(e, "multiinfo"),
+		ResultCount: len(results),
+		Results:     results,
 	})
 }
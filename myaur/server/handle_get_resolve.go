@@ -0,0 +1,39 @@
+package server
+
+import (
+	"github.com/haileyok/myaur/myaur/resolve"
+	"github.com/labstack/echo/v4"
+)
+
+type ApiError struct {
+	Error string `json:"error"`
+}
+
+func makeApiErrJson(error string) ApiError {
+	return ApiError{
+		Error: error,
+	}
+}
+
+// handleGetResolve returns every aur package base that needs to be built for the packages given in `pkg`, in the
+// order they need to be built. i.e. /api/resolve?pkg=foo&pkg=bar&arch=x86_64. dependencies that only something else
+// provides are only built with `provides=true`
+func (s *Server) handleGetResolve(e echo.Context) error {
+	logger := s.logger.With("handler", "getResolve")
+
+	names := e.QueryParams()["pkg"]
+	if len(names) == 0 {
+		return e.JSON(400, makeApiErrJson("Missing `pkg` parameter"))
+	}
+
+	result, err := s.resolver.Resolve(names, resolve.Options{
+		Arch:           e.QueryParam("arch"),
+		FollowProvides: e.QueryParam("provides") == "true",
+	})
+	if err != nil {
+		logger.Error("failed to resolve packages", "err", err)
+		return e.JSON(500, makeApiErrJson("Failed to resolve packages"))
+	}
+
+	return e.JSON(200, result)
+}
@@ -0,0 +1,34 @@
+package server
+
+import (
+	"github.com/labstack/echo/v4"
+)
+
+type GetReverseDepsInput struct {
+	Transitive bool `query:"transitive"`
+}
+
+// handleGetReverseDeps returns every package that depends on the given package, split up by the kind of
+// dependency. i.e. /api/reverse-deps/foo?transitive=true
+func (s *Server) handleGetReverseDeps(e echo.Context) error {
+	logger := s.logger.With("handler", "getReverseDeps")
+
+	var input GetReverseDepsInput
+	if err := e.Bind(&input); err != nil {
+		logger.Error("failed to bind request", "err", err)
+		return e.JSON(400, makeApiErrJson("Failed to bind request"))
+	}
+
+	name := e.Param("name")
+	if name == "" {
+		return e.JSON(400, makeApiErrJson("Missing package name"))
+	}
+
+	result, err := s.resolver.ReverseDeps(name, input.Transitive)
+	if err != nil {
+		logger.Error("failed to lookup reverse dependencies", "name", name, "err", err)
+		return e.JSON(500, makeApiErrJson("Failed to lookup reverse dependencies"))
+	}
+
+	return e.JSON(200, result)
+}
@@ -2,43 +2,174 @@ package server
 
 import (
 	"net/http"
+	"regexp"
+	"strconv"
 
 	"github.com/labstack/echo/v4"
 )
 
-func (s *Server) handleRpc(e echo.Context) error {
-	queryParams := e.QueryParams()
-	rpcType := e.QueryParam("type")
-	version := e.QueryParam("v")
+// the same callback names the aur allows for jsonp
+var callbackRegex = regexp.MustCompile(`^[a-zA-Z0-9()_.]{1,128}$`)
+
+const (
+	// the rpc version that gets used by the /rpc/v5 routes, and the newest one we understand
+	rpcLatestVersion = 5
+
+	// keys used to pass the version and type of a /rpc request along to the handler for that type
+	rpcVersionKey = "rpcVersion"
+	rpcTypeKey    = "rpcType"
+)
+
+// the `type` that gets returned for each request type, where it differs from the request
+var rpcTypeAliases = map[string]string{
+	"info":  "multiinfo",
+	"query": "multiinfo",
+}
 
-	if version == "" {
-		version = "5"
+// rpcArgs returns the `arg` values of an rpc request. they may be given as either `arg[]` or `arg`, and either in
+// the query string or in a form encoded POST body, which helpers use when asking for too many packages to fit in a url
+func rpcArgs(e echo.Context) ([]string, error) {
+	params, err := e.FormParams()
+	if err != nil {
+		return nil, err
 	}
 
-	// HACK: there's probably a better way to do this with echo...
-	args := queryParams["arg[]"]
+	args := params["arg[]"]
 	if len(args) == 0 {
-		args = queryParams["arg"]
+		args = params["arg"]
+	}
+	return args, nil
+}
+
+// bindRpc binds the parameters of an rpc request, from both the query string and a form encoded POST body. echo
+// only binds the body for POST requests, so the query string gets bound separately
+func bindRpc(e echo.Context, i any) error {
+	if err := e.Bind(i); err != nil {
+		return err
+	}
+	if e.Request().Method == http.MethodPost {
+		return (&echo.DefaultBinder{}).BindQueryParams(e, i)
+	}
+	return nil
+}
+
+// rpcVersion returns the version of the rpc request. a nil version is only returned if the client didn't supply a
+// valid one to /rpc
+func rpcVersion(e echo.Context) *int {
+	if v, ok := e.Get(rpcVersionKey).(*int); ok {
+		return v
+	}
+	v := rpcLatestVersion
+	return &v
+}
+
+// rpcType returns the `type` to put in the response for the rpc request, falling back to the given type for the
+// /rpc/v5 routes
+func rpcType(e echo.Context, fallback string) string {
+	if t, ok := e.Get(rpcTypeKey).(string); ok {
+		return t
+	}
+	return fallback
+}
+
+// rpcJson sends an rpc response, wrapping it in the requested jsonp `callback` if there is one
+func rpcJson(e echo.Context, code int, i any) error {
+	callback := e.FormValue("callback")
+	if callback == "" {
+		return e.JSON(code, i)
+	}
+
+	if !callbackRegex.MatchString(callback) {
+		return e.JSON(http.StatusOK, makeErrJson(rpcVersion(e), "Invalid callback name."))
+	}
+
+	return e.JSONP(code, callback, i)
+}
+
+// rpcError sends an rpc error response. the aur responds to bad requests with a 200 and an error type, so the code
+// should only be something else for errors on our end
+func rpcError(e echo.Context, code int, error string) error {
+	return rpcJson(e, code, makeErrJson(rpcVersion(e), error))
+}
+
+// handleRpc handles requests to /rpc, which is what most aur helpers use. the type of request is given in `type`
+// (search, msearch, info, multiinfo, suggest or suggest-pkgbase) and the api version in `v`. errors are returned
+// the same way the aur returns them, with a 200 and a `type` of `error`
+func (s *Server) handleRpc(e echo.Context) error {
+	reqType := e.FormValue("type")
+
+	// the aur checks the version before anything else, and won't assume one if it's missing
+	if e.FormValue("v") == "" {
+		e.Set(rpcVersionKey, (*int)(nil))
+		return rpcError(e, http.StatusOK, "Please specify an API version.")
+	}
+	version, err := strconv.Atoi(e.FormValue("v"))
+	if err != nil {
+		e.Set(rpcVersionKey, (*int)(nil))
+		return rpcError(e, http.StatusOK, "Invalid version specified.")
+	}
+	e.Set(rpcVersionKey, &version)
+	if version < 1 || version > rpcLatestVersion {
+		return rpcError(e, http.StatusOK, "Invalid version specified.")
+	}
+
+	if by := e.FormValue("by"); by != "" {
+		if _, ok := GetSearchInputByAllowedValues[by]; !ok {
+			return rpcError(e, http.StatusOK, "Incorrect by field specified.")
+		}
+	}
+
+	// older versions returned the type that was asked for, rather than aliasing info to multiinfo
+	respType := reqType
+	if alias, ok := rpcTypeAliases[reqType]; ok && version >= 5 {
+		respType = alias
 	}
+	e.Set(rpcTypeKey, respType)
+
+	args, err := rpcArgs(e)
+	if err != nil {
+		return rpcError(e, http.StatusOK, "Failed to parse request")
+	}
+
+	switch reqType {
+	case "search", "msearch":
+		// msearch is a search by maintainer, where an empty maintainer finds orphaned packages. the same goes for a
+		// search that asks for by=maintainer
+		if reqType != "msearch" && e.FormValue("by") != "maintainer" && len(args) == 0 {
+			return rpcError(e, http.StatusOK, "No request type/data specified.")
+		}
 
-	switch rpcType {
-	case "search":
 		// there will be a single arg in search, since it does a `like` match
-		if len(args) == 0 {
-			return e.JSON(http.StatusBadRequest, makeErrJson("Missing `arg` parameter"))
+		var term string
+		if len(args) > 0 {
+			term = args[0]
 		}
 		e.SetPath("/rpc/v5/search/:term")
 		e.SetParamNames("term")
-		e.SetParamValues(args[0])
+		e.SetParamValues(term)
 		return s.handleGetSearch(e)
-	case "info", "query":
+	case "info", "multiinfo", "query":
 		// there whould be an array, i.e. arg[]=discord-canary&arg[]=slack
 		if len(args) == 0 {
-			return e.JSON(http.StatusBadRequest, makeErrJson("Missing `arg` parameter"))
+			return rpcError(e, http.StatusOK, "No request type/data specified.")
 		}
 		e.SetPath("/rpc/v5/info")
 		return s.handleGetInfo(e)
+	case "suggest", "suggest-pkgbase":
+		// the arg is optional here, and the aur suggests nothing without one
+		var term string
+		if len(args) > 0 {
+			term = args[0]
+		}
+		e.SetParamNames("term")
+		e.SetParamValues(term)
+		if reqType == "suggest" {
+			e.SetPath("/rpc/v5/suggest/:term")
+			return s.handleGetSuggest(e)
+		}
+		e.SetPath("/rpc/v5/suggest-pkgbase/:term")
+		return s.handleGetSuggestPkgbase(e)
 	default:
-		return e.JSON(http.StatusBadRequest, makeErrJson("Missing or invalid `type` parameter"))
+		return rpcError(e, http.StatusOK, "Incorrect request type specified.")
 	}
 }
@@ -0,0 +1,222 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/haileyok/myaur/myaur/database"
+	"github.com/haileyok/myaur/myaur/srcinfo"
+	"github.com/labstack/echo/v4"
+)
+
+// the maintainer of each package base in testdata/rpc/packages. anything not in here is orphaned
+var fixtureMaintainers = map[string]string{
+	"yay":          "alice",
+	"yay-bin":      "bob",
+	"python-foo":   "bob",
+	"linux-xanmod": "bob",
+}
+
+// the co-maintainers of each package base in testdata/rpc/packages
+var fixtureCoMaintainers = map[string][]string{
+	"yay": {"carol"},
+}
+
+// newRpcServer creates a server with a database holding every package in testdata/rpc/packages
+func newRpcServer(t *testing.T) *Server {
+	t.Helper()
+
+	db, err := database.New(&database.Args{
+		DatabasePath: filepath.Join(t.TempDir(), "myaur.db"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := filepath.Glob("testdata/rpc/packages/*.SRCINFO")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var updates []database.BranchUpdate
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pkgs, err := srcinfo.Parse(string(content))
+		if err != nil {
+			t.Fatalf("failed to parse %s: %v", file, err)
+		}
+
+		branch := strings.TrimSuffix(filepath.Base(file), ".SRCINFO")
+		update := database.BranchUpdate{Branch: branch, Commit: branch}
+		for _, pkg := range pkgs {
+			pkg.Maintainer = fixtureMaintainers[branch]
+			pkg.Submitter = "alice"
+			pkg.CoMaintainers = fixtureCoMaintainers[branch]
+			pkg.FirstSubmitted = 1700000000
+			pkg.LastModified = 1720000000
+			update.Packages = append(update.Packages, database.PackageUpdate{
+				Package:   *pkg,
+				Relations: srcinfo.Relations(pkg),
+			})
+		}
+		updates = append(updates, update)
+	}
+
+	if _, err := db.ApplyBranchUpdates(updates); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{
+		logger:     slog.New(slog.NewJSONHandler(io.Discard, nil)),
+		echo:       echo.New(),
+		db:         db,
+		maxResults: 3,
+	}
+	s.addRoutes()
+
+	return s
+}
+
+// jsonpRegex splits a jsonp response into its callback and json. aurweb prefixes the callback with an empty comment
+// and echo ends it with a semicolon, neither of which matter to a client
+var jsonpRegex = regexp.MustCompile(`(?s)^(?:/\*\*/)?([\w.]+)\((.*)\);?\n?$`)
+
+// decodeRpc decodes an rpc response so it can be compared without caring about the order of keys or whitespace.
+// jsonp responses are returned with their callback
+func decodeRpc(body []byte) (callback string, v any, err error) {
+	if m := jsonpRegex.FindSubmatch(body); m != nil {
+		callback, body = string(m[1]), m[2]
+	}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return "", nil, err
+	}
+	return callback, v, nil
+}
+
+// TestRpcGolden checks the rpc responses against the ones in testdata/rpc. those are written by hand to be what
+// aurweb returns for the same packages and requests, so they should never be regenerated from our own responses. the
+// one difference is URLPath, which aurweb points at a snapshot tarball that myaur doesn't serve
+func TestRpcGolden(t *testing.T) {
+	s := newRpcServer(t)
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		// a form encoded body for POST requests
+		form url.Values
+		// set to send something other than a form
+		contentType string
+		body        string
+	}{
+		{name: "info", target: "/rpc?v=5&type=info&arg=yay"},
+		{name: "info_multiple", target: "/rpc?v=5&type=info&arg[]=yay&arg[]=libfoo&arg[]=does-not-exist"},
+		{name: "info_split", target: "/rpc?v=5&type=info&arg[]=linux-xanmod&arg[]=linux-xanmod-headers"},
+		{name: "info_arch", target: "/rpc?v=5&type=info&arg=yay&arch=x86_64"},
+		{name: "info_multiinfo", target: "/rpc?v=5&type=multiinfo&arg[]=yay-bin"},
+		{name: "info_v4", target: "/rpc?v=4&type=info&arg=yay"},
+		{name: "info_post", method: http.MethodPost, target: "/rpc", form: url.Values{"v": {"5"}, "type": {"info"}, "arg[]": {"yay", "python-foo"}}},
+		{name: "info_v5_route", target: "/rpc/v5/info?arg[]=libfoo"},
+		{name: "info_missing", target: "/rpc?v=5&type=info&arg=does-not-exist"},
+
+		{name: "search_name_desc", target: "/rpc?v=5&type=search&arg=yogurt"},
+		{name: "search_name", target: "/rpc?v=5&type=search&by=name&arg=yay"},
+		{name: "search_depends", target: "/rpc?v=5&type=search&by=depends&arg=libfoo"},
+		{name: "search_provides", target: "/rpc?v=5&type=search&by=provides&arg=yay"},
+		{name: "search_groups", target: "/rpc?v=5&type=search&by=groups&arg=foo-tools"},
+		{name: "search_maintainer", target: "/rpc?v=5&type=search&by=maintainer&arg=alice"},
+		{name: "search_msearch", target: "/rpc?v=5&type=msearch&arg=alice"},
+		{name: "search_orphans", target: "/rpc?v=5&type=msearch"},
+		{name: "search_maintainer_no_arg", target: "/rpc?v=5&type=search&by=maintainer"},
+		{name: "search_v5_route", target: "/rpc/v5/search/xanmod?by=name"},
+		{name: "search_no_results", target: "/rpc?v=5&type=search&arg=does-not-exist"},
+		{name: "search_post", method: http.MethodPost, target: "/rpc", form: url.Values{"v": {"5"}, "type": {"search"}, "by": {"name"}, "arg": {"python"}}},
+
+		{name: "suggest", target: "/rpc?v=5&type=suggest&arg=ya"},
+		{name: "suggest_pkgbase", target: "/rpc?v=5&type=suggest-pkgbase&arg=linux"},
+		{name: "suggest_v5_route", target: "/rpc/v5/suggest/linux-x"},
+		{name: "suggest_case", target: "/rpc?v=5&type=suggest&arg=YA"},
+		{name: "suggest_empty", target: "/rpc?v=5&type=suggest"},
+
+		{name: "error_no_version", target: "/rpc?type=info&arg=yay"},
+		{name: "error_bad_version", target: "/rpc?v=6&type=info&arg=yay"},
+		{name: "error_invalid_version", target: "/rpc?v=five&type=info&arg=yay"},
+		{name: "error_bad_type", target: "/rpc?v=5&type=nope&arg=yay"},
+		{name: "error_no_type", target: "/rpc?v=5&arg=yay"},
+		{name: "error_bad_by", target: "/rpc?v=5&type=search&by=nope&arg=yay"},
+		{name: "error_no_arg", target: "/rpc?v=5&type=info"},
+		{name: "error_search_no_arg", target: "/rpc?v=5&type=search"},
+		{name: "error_query_too_small", target: "/rpc?v=5&type=search&arg=y"},
+		{name: "error_too_many_results", target: "/rpc?v=5&type=msearch&arg=bob"},
+		{name: "error_bad_body", method: http.MethodPost, target: "/rpc/v5/search/yay", contentType: echo.MIMEApplicationJSON, body: "{"},
+
+		{name: "jsonp", target: "/rpc?v=5&type=info&arg=libfoo&callback=jQuery_123.cb"},
+		{name: "jsonp_error", target: "/rpc?v=5&type=nope&callback=cb"},
+		{name: "jsonp_suggest", target: "/rpc?v=5&type=suggest&arg=yay&callback=cb"},
+		{name: "jsonp_bad_callback", target: "/rpc?v=5&type=info&arg=yay&callback=alert%281%29%3B"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+
+			var body io.Reader
+			contentType := tt.contentType
+			switch {
+			case tt.form != nil:
+				body = strings.NewReader(tt.form.Encode())
+				contentType = echo.MIMEApplicationForm
+			case tt.body != "":
+				body = strings.NewReader(tt.body)
+			}
+
+			req := httptest.NewRequest(method, tt.target, body)
+			if contentType != "" {
+				req.Header.Set(echo.HeaderContentType, contentType)
+			}
+			rec := httptest.NewRecorder()
+			s.echo.ServeHTTP(rec, req)
+
+			// the aur answers bad requests with a 200 and an error type, so everything should be a 200
+			if rec.Code != http.StatusOK {
+				t.Errorf("got status %d, want %d", rec.Code, http.StatusOK)
+			}
+
+			golden := filepath.Join("testdata", "rpc", tt.name+".golden")
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			gotCallback, got, err := decodeRpc(rec.Body.Bytes())
+			if err != nil {
+				t.Fatalf("response is not valid json: %v\n%s", err, rec.Body.Bytes())
+			}
+			wantCallback, wantJson, err := decodeRpc(want)
+			if err != nil {
+				t.Fatalf("%s is not valid json: %v", golden, err)
+			}
+
+			if gotCallback != wantCallback || !reflect.DeepEqual(got, wantJson) {
+				t.Errorf("response doesn't match %s\ngot:\n%s\nwant:\n%s", golden, rec.Body.Bytes(), want)
+			}
+		})
+	}
+}
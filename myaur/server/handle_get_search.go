@@ -5,94 +5,203 @@ import (
 	"github.com/labstack/echo/v4"
 )
 
+// defaultSearchBy is the field searched when a search doesn't give a `by`, the same as the aur
+const defaultSearchBy = "name-desc"
+
 var (
 	GetSearchInputByAllowedValues = map[string]struct{}{
-		"name":         {},
-		"name-desc":    {},
-		"maintainer":   {},
-		"depends":      {},
-		"makedepends":  {},
-		"optdepends":   {},
-		"checkdepends": {},
+		"name":          {},
+		"name-desc":     {},
+		"maintainer":    {},
+		"depends":       {},
+		"makedepends":   {},
+		"optdepends":    {},
+		"checkdepends":  {},
+		"provides":      {},
+		"conflicts":     {},
+		"replaces":      {},
+		"groups":        {},
+		"keywords":      {},
+		"submitter":     {},
+		"comaintainers": {},
+	}
+
+	GetSearchInputSortAllowedValues = map[string]struct{}{
+		database.SortName:         {},
+		database.SortVotes:        {},
+		database.SortPopularity:   {},
+		database.SortLastModified: {},
+		database.SortVersion:      {},
 	}
 )
 
 type GetSearchInput struct {
-	By string `query:"by"`
+	By    string `query:"by" form:"by"`
+	Sort  string `query:"sort" form:"sort"`
+	Order string `query:"order" form:"order"`
 }
 
 type GetSearchOutput struct {
-	Version     int                    `json:"version"`
-	Type        string                 `json:"type"`
-	ResultCount int                    `json:"resultcount"`
-	Results     []database.PackageInfo `json:"results"`
-	Error       *string                `json:"error,omitempty"`
+	// null when the client didn't give a valid version
+	Version     *int    `json:"version"`
+	Type        string  `json:"type"`
+	ResultCount int     `json:"resultcount"`
+	Results     any     `json:"results"`
+	Error       *string `json:"error,omitempty"`
 }
 
-func makeErrJson(error string) GetSearchOutput {
+// SearchResult is a package the way the aur returns it from a search. searches only give the fields that are on the
+// package itself, and everything else (relations, licenses, submitter, etc) is only returned by info
+type SearchResult struct {
+	ID             int64   `json:"ID"`
+	Name           string  `json:"Name"`
+	PackageBaseID  int64   `json:"PackageBaseID"`
+	PackageBase    string  `json:"PackageBase"`
+	Version        string  `json:"Version"`
+	Description    string  `json:"Description"`
+	URL            string  `json:"URL"`
+	URLPath        string  `json:"URLPath"`
+	NumVotes       int64   `json:"NumVotes"`
+	Popularity     float64 `json:"Popularity"`
+	OutOfDate      *int64  `json:"OutOfDate"`
+	Maintainer     *string `json:"Maintainer"`
+	FirstSubmitted int64   `json:"FirstSubmitted"`
+	LastModified   int64   `json:"LastModified"`
+}
+
+// nullString returns nil for an empty string, since the aur gives null for users that don't exist, i.e. the
+// maintainer of an orphaned package
+func nullString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+func makeSearchResult(pkg *database.PackageInfo) SearchResult {
+	return SearchResult{
+		ID:             pkg.Id,
+		Name:           pkg.Name,
+		PackageBaseID:  pkg.PackageBaseID,
+		PackageBase:    pkg.PackageBase,
+		Version:        pkg.Version,
+		Description:    pkg.Description,
+		URL:            pkg.Url,
+		URLPath:        pkg.UrlPath,
+		NumVotes:       pkg.NumVotes,
+		Popularity:     pkg.Popularity,
+		OutOfDate:      pkg.OutOfDate,
+		Maintainer:     nullString(pkg.Maintainer),
+		FirstSubmitted: pkg.FirstSubmitted,
+		LastModified:   pkg.LastModified,
+	}
+}
+
+func makeErrJson(version *int, error string) GetSearchOutput {
 	return GetSearchOutput{
-		Version:     5,
+		Version:     version,
 		Type:        "error",
 		ResultCount: 0,
-		Results:     []database.PackageInfo{},
+		Results:     []SearchResult{},
 		Error:       &error,
 	}
 }
 
+// mergeArch adds the architecture specific fields for the given arch onto each package. if no arch is supplied,
+// only the generic fields are returned
+func mergeArch(pkgs []database.PackageInfo, arch string) {
+	if arch == "" {
+		return
+	}
+	for i := range pkgs {
+		pkgs[i].MergeArch(arch)
+	}
+}
+
 // Depending on what the `by` parameter is, should receive one of the following as path:
 // `name`: search by package name
-// `name-desc`: search by package name and description
+// `name-desc`: search by package name and description (the default, same as the aur)
 // `maintainer`: search by maintainer name
 // `depends`: search for packages that depend on a keyword
 // `makedepends`: search for packages that makedepend on a keyword
 // `optdepends`: search for packages that optdepends on a keyword
 // `checkdepends`: search for packages that checkdepends on a keyword
+// `provides`: search for packages that provide a keyword
+// `conflicts`: search for packages that conflict with a keyword
+// `replaces`: search for packages that replace a keyword
+// `groups`: search for packages that are in a group
+// `keywords`: search for packages that have a keyword
+// `submitter`: search by submitter name
+// `comaintainers`: search by co-maintainer name
 func (s *Server) handleGetSearch(e echo.Context) error {
 	logger := s.logger.With("handler", "getSearch")
 
 	var input GetSearchInput
-	if err := e.Bind(&input); err != nil {
+	if err := bindRpc(e, &input); err != nil {
 		logger.Error("failed to bind request", "err", err)
-		return e.JSON(400, makeErrJson("Failed to bind request"))
+		return rpcError(e, 200, "Failed to bind request")
 	}
 
 	logger = logger.With("input", input)
 
-	if input.By != "" {
+	respType := rpcType(e, "search")
+
+	if respType == "msearch" {
+		input.By = "maintainer"
+	} else if input.By != "" {
 		if _, ok := GetSearchInputByAllowedValues[input.By]; !ok {
 			logger.Error("invalid by supplied", "by", input.By)
-			return e.JSON(400, makeErrJson("Invalid `by` supplied. Valid values are name, name-desc, maintainer, depends, optdepends, checkdepends"))
+			return rpcError(e, 200, "Incorrect by field specified.")
 		}
 	} else {
-		input.By = "name"
+		input.By = defaultSearchBy
+	}
+
+	if input.Sort != "" {
+		if _, ok := GetSearchInputSortAllowedValues[input.Sort]; !ok {
+			logger.Error("invalid sort supplied", "sort", input.Sort)
+			return rpcError(e, 200, "Invalid `sort` supplied. Valid values are name, votes, popularity, modified, version")
+		}
+	}
+
+	if input.Order != "" && input.Order != "asc" && input.Order != "desc" {
+		logger.Error("invalid order supplied", "order", input.Order)
+		return rpcError(e, 200, "Invalid `order` supplied. Valid values are asc, desc")
 	}
 
 	term := e.Param("term")
 
-	var pkgs []database.PackageInfo
-	var err error
-	switch input.By {
-	case "name":
-		pkgs, err = s.db.GetPackagesByName(term)
-	case "name-desc":
-		pkgs, err = s.db.GetPackagesByDescriptionOrName(term)
-	default:
-		return e.JSON(500, makeErrJson("Search method not implemented"))
-		// case "maintainer":
-		// case "depends":
-		// case "makedepends":
-		// case "optdepends":
-		// case "checkdepends":
+	// searching by maintainer with no maintainer returns every orphaned package, but for everything else the aur
+	// refuses to search for less than two characters
+	if input.By != "maintainer" && len(term) < 2 {
+		return rpcError(e, 200, "Query arg too small.")
 	}
 
+	// ask for one more than the max, so we know if there are too many results without having to count them
+	pkgs, err := s.db.SearchPackages(input.By, term, &database.SearchOptions{
+		Limit: s.maxResults + 1,
+		Sort:  input.Sort,
+		Desc:  input.Order == "desc",
+	})
 	if err != nil {
-		return e.JSON(500, makeErrJson("Error searching for packages"))
+		logger.Error("failed to search for packages", "err", err)
+		return rpcError(e, 500, "Error searching for packages")
+	}
+
+	if len(pkgs) > s.maxResults {
+		return rpcError(e, 200, "Too many package results.")
+	}
+
+	// none of the arch specific fields are in search results, so there's nothing to merge
+	results := make([]SearchResult, 0, len(pkgs))
+	for i := range pkgs {
+		results = append(results, makeSearchResult(&pkgs[i]))
 	}
 
-	return e.JSON(200, GetSearchOutput{
-		Version:     5,
-		Type:        "search",
-		ResultCount: len(pkgs),
-		Results:     pkgs,
+	return rpcJson(e, 200, GetSearchOutput{
+		Version:     rpcVersion(e),
+		Type:        respType,
+		ResultCount: len(results),
+		Results:     results,
 	})
 }
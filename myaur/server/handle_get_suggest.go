@@ -0,0 +1,35 @@
+package server
+
+import (
+	"github.com/labstack/echo/v4"
+)
+
+// the aur returns at most this many names for a suggestion
+const suggestLimit = 20
+
+// handleGetSuggest returns the names of up to 20 packages starting with the given term, ignoring case, as a bare
+// json array like the aur does. these are used by shell completions and search boxes, so get requested on every keystroke
+func (s *Server) handleGetSuggest(e echo.Context) error {
+	logger := s.logger.With("handler", "getSuggest")
+
+	names, err := s.db.SuggestPackageNames(e.Param("term"), suggestLimit)
+	if err != nil {
+		logger.Error("failed to suggest packages", "err", err)
+		return rpcError(e, 500, "Error searching for packages")
+	}
+
+	return rpcJson(e, 200, names)
+}
+
+// handleGetSuggestPkgbase is the same as handleGetSuggest, but for package bases rather than package names
+func (s *Server) handleGetSuggestPkgbase(e echo.Context) error {
+	logger := s.logger.With("handler", "getSuggestPkgbase")
+
+	names, err := s.db.SuggestPackageBases(e.Param("term"), suggestLimit)
+	if err != nil {
+		logger.Error("failed to suggest package bases", "err", err)
+		return rpcError(e, 500, "Error searching for package bases")
+	}
+
+	return rpcJson(e, 200, names)
+}
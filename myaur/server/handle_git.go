@@ -2,11 +2,19 @@ package server
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
 	"fmt"
 	"io"
-	"os/exec"
+	"log/slog"
+	"net/http"
+	"slices"
 	"strings"
+	"sync"
 
+	"github.com/haileyok/myaur/myaur/gitrepo"
+	"github.com/haileyok/myaur/myaur/pktline"
 	"github.com/labstack/echo/v4"
 )
 
@@ -34,103 +42,488 @@ func (s *Server) handleGit(e echo.Context) error {
 		gitPath = pts[1]
 	}
 
-	// return a symref pointing to master for head requests
-	if gitPath == "HEAD" {
-		e.Response().Header().Set("Content-Type", "text/plain")
-		return e.String(200, "ref: refs/heads/master\n")
+	// every package looks like its own repo, so anything under a package that doesn't exist is a 404. this only
+	// resolves the branch, the rest of the package's refs are looked up by the requests that need them
+	head, err := s.repo.ResolveBranch(packageName)
+	if err != nil {
+		s.logger.Debug("branch not found", "route", "handleGit", "package-name", packageName, "err", err)
+		return e.String(404, "Package not found")
 	}
 
-	// handle info/refs request
-	if gitPath == "info/refs" && strings.Contains(e.Request().URL.RawQuery, "service=git-upload-pack") {
-		return s.serveInfoRefs(e, packageName)
+	switch gitPath {
+	case "info/refs":
+		// clients that don't send a service are using the dumb protocol
+		switch e.QueryParam("service") {
+		case "":
+			return s.serveDumbInfoRefs(e, packageName, head)
+		case "git-upload-pack":
+			return s.serveInfoRefs(e, packageName, head)
+		default:
+			return e.String(403, "Unsupported service")
+		}
+	case "git-upload-pack":
+		return s.serveUploadPack(e, packageName, head)
+	case "HEAD":
+		return s.serveDumbHead(e)
+	case "objects/info/packs":
+		return s.serveDumbPacks(e)
 	}
 
-	// handle git-upload-pack request
-	if gitPath == "git-upload-pack" {
-		return s.serveUploadPack(e, packageName)
+	if hash, ok := looseObjectHash(gitPath); ok {
+		return s.serveDumbObject(e, packageName, head, hash)
 	}
 
 	return e.String(404, "Not Found")
 }
 
-func (s *Server) serveInfoRefs(e echo.Context, packageName string) error {
-	logger := s.logger.With("route", "handleGit", "git-component", "serveInfoRefs", "package-name", packageName)
+// packageBranch is the name of the package's branch in the mirror
+func packageBranch(packageName string) string {
+	return "refs/heads/" + packageName
+}
 
-	cmd := exec.Command("git", "-C", s.repoPath, "show-ref", fmt.Sprintf("refs/heads/%s", packageName))
-	output, err := cmd.Output()
+// packageRefNames maps each ref the package's repo appears to have to the ref in the mirror that it really is. HEAD
+// and master are both the package's branch, and tags keep their names
+func packageRefNames(packageName string, refs []advertisedRef) map[string]string {
+	names := make(map[string]string, len(refs))
+	for _, ref := range refs {
+		if ref.Target != "" || strings.HasPrefix(ref.Name, "refs/heads/") {
+			names[ref.Name] = packageBranch(packageName)
+			continue
+		}
+		names[ref.Name] = ref.Name
+	}
+	return names
+}
+
+// advertisedRef is a ref in the repo that a package appears to have
+type advertisedRef struct {
+	Name string
+	Hash string
+	// for symrefs like HEAD, the ref that this one points to
+	Target string
+	// for annotated tags, the commit that the tag points at
+	Peeled string
+}
+
+// packageRefs returns the refs that the package's repo appears to have, given the commit its branch is at. each
+// package is a single branch in the mirror, but to clients it looks like its own repo with a master branch, along
+// with any tags in the branch's history
+func (s *Server) packageRefs(packageName, commitHash string) ([]advertisedRef, error) {
+	tags, err := s.repo.ListTags(packageName)
 	if err != nil {
-		logger.Error("branch not found", "err", err)
-		return e.String(404, "Package not found")
+		return nil, err
+	}
+	slices.SortFunc(tags, func(a, b gitrepo.Tag) int { return strings.Compare(a.Name, b.Name) })
+
+	refs := []advertisedRef{
+		{Name: "HEAD", Hash: commitHash, Target: "refs/heads/master"},
+		{Name: "refs/heads/master", Hash: commitHash},
+	}
+	for _, tag := range tags {
+		refs = append(refs, advertisedRef{Name: tag.Name, Hash: tag.Hash, Peeled: tag.Peeled})
 	}
 
-	refLine := strings.TrimSpace(string(output))
-	refParts := strings.Fields(refLine)
-	if len(refParts) != 2 {
-		logger.Error("invalid ref format", "output", refLine)
-		return e.String(500, "Invalid ref format")
+	return refs, nil
+}
+
+// packageRefResolver returns a resolve function for pktline.NewRefRewriter. most requests never ask for a ref by
+// name, so the package's refs are only looked up the first time one does
+func (s *Server) packageRefResolver(packageName, commitHash string) func(name string) (string, error) {
+	var once sync.Once
+	var resolve func(name string) (string, error)
+
+	return func(name string) (string, error) {
+		once.Do(func() {
+			refs, err := s.packageRefs(packageName, commitHash)
+			if err != nil {
+				resolve = func(string) (string, error) { return "", fmt.Errorf("failed to list refs: %w", err) }
+				return
+			}
+			resolve = pktline.MapResolver(packageRefNames(packageName, refs))
+		})
+		return resolve(name)
 	}
+}
+
+// reachableCacheSize is how many packages' reachable objects are kept around at once
+const reachableCacheSize = 128
 
-	commitHash := refParts[0]
+// reachableCache holds the objects reachable from each package's refs, so a dumb clone that fetches every object one
+// at a time (or a fetch with lots of wants) only walks the package's history once. the zero value is ready to use
+type reachableCache struct {
+	mu      sync.Mutex
+	entries map[string]reachableEntry
+}
+
+type reachableEntry struct {
+	// the package's branch head when the objects were listed. the entry is stale once the branch moves
+	head    string
+	objects map[string]struct{}
+}
+
+// contains checks if hash is reachable from the package, calling list to get every reachable object if the package's
+// objects aren't cached for the given branch head
+func (c *reachableCache) contains(packageName, head, hash string, list func() ([]string, error)) (bool, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[packageName]
+	c.mu.Unlock()
+
+	if !ok || entry.head != head {
+		// listing can take a while, so it's done outside of the lock. two requests for the same package may both end
+		// up listing its objects, which is fine
+		objects, err := list()
+		if err != nil {
+			return false, err
+		}
+
+		entry = reachableEntry{head: head, objects: make(map[string]struct{}, len(objects))}
+		for _, obj := range objects {
+			entry.objects[obj] = struct{}{}
+		}
+
+		c.mu.Lock()
+		if c.entries == nil {
+			c.entries = make(map[string]reachableEntry)
+		}
+		if _, ok := c.entries[packageName]; !ok && len(c.entries) >= reachableCacheSize {
+			// make room by dropping whichever package comes up first
+			for name := range c.entries {
+				delete(c.entries, name)
+				break
+			}
+		}
+		c.entries[packageName] = entry
+		c.mu.Unlock()
+	}
+
+	_, ok = entry.objects[hash]
+	return ok, nil
+}
+
+// packageReachable checks if the object is reachable from the package's refs. every package shares the mirror's
+// objects, so this is what keeps one package's repo from handing out another's. the package's refs only get looked
+// up when its objects aren't already cached
+func (s *Server) packageReachable(packageName, commitHash, hash string) (bool, error) {
+	return s.reachable.contains(packageName, commitHash, hash, func() ([]string, error) {
+		refs, err := s.packageRefs(packageName, commitHash)
+		if err != nil {
+			return nil, err
+		}
+
+		var tips []string
+		for _, ref := range refs {
+			tips = append(tips, ref.Hash)
+		}
+		return s.repo.ListObjects(tips)
+	})
+}
+
+// newPackageRewriter creates the RefRewriter for an upload-pack request to the package, which only lets the client
+// ask for the package's refs and objects
+func (s *Server) newPackageRewriter(packageName, commitHash string) *pktline.RefRewriter {
+	return pktline.NewRefRewriter(s.packageRefResolver(packageName, commitHash), func(hash string) (bool, error) {
+		return s.packageReachable(packageName, commitHash, hash)
+	})
+}
+
+// isProtocolV2 checks if the client asked to speak protocol v2, which is sent in the Git-Protocol header as a list of
+// colon separated parameters
+func isProtocolV2(r *http.Request) bool {
+	for param := range strings.SplitSeq(r.Header.Get("Git-Protocol"), ":") {
+		if param == "version=2" {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) serveInfoRefs(e echo.Context, packageName, commitHash string) error {
+	e.Response().Header().Set("Cache-Control", "no-cache")
+
+	// clients that can speak protocol v2 ask for it, and fall back to v0 if they get a v0 advertisement back
+	if isProtocolV2(e.Request()) {
+		if features := s.repo.UploadPackV2Capabilities(); features != nil {
+			return e.Blob(200, "application/x-git-upload-pack-advertisement", advertiseV2(features))
+		}
+	}
+
+	refs, err := s.packageRefs(packageName, commitHash)
+	if err != nil {
+		s.logger.Error("failed to list refs", "route", "handleGit", "package-name", packageName, "err", err)
+		return e.String(500, "Failed to list refs")
+	}
 
 	// WARNING: SLOP CODE
 	// claude apparently knows how to create these smart HTPP responses for git. it works on my machine,
 	// but...lol
 	var buf bytes.Buffer
+	pw := pktline.NewWriter(&buf)
 
 	// Write packet: service announcement
-	service := "# service=git-upload-pack\n"
-	buf.WriteString(fmt.Sprintf("%04x%s", len(service)+4, service))
-	buf.WriteString("0000") // flush packet
+	pw.WriteString("# service=git-upload-pack\n")
+	pw.Flush()
 
-	// Advertise HEAD and master branch
 	// Format: hash + SP + ref + NULL + capabilities + LF
-	// NOTE: these were the ones claude kept adding until yay didn't yell at me anymore. not sure if they are all needed though
-	capabilities := "multi_ack multi_ack_detailed thin-pack side-band side-band-64k ofs-delta shallow no-progress include-tag symref=HEAD:refs/heads/master"
-
-	// Advertise HEAD first with symref capability
-	headRef := fmt.Sprintf("%s HEAD\x00%s\n", commitHash, capabilities)
-	buf.WriteString(fmt.Sprintf("%04x%s", len(headRef)+4, headRef))
+	// the capabilities depend on what the git backend's upload-pack supports
+	capabilities := s.repo.UploadPackCapabilities()
+	for _, ref := range refs {
+		if ref.Target != "" {
+			capabilities = append(capabilities, fmt.Sprintf("symref=%s:%s", ref.Name, ref.Target))
+		}
+	}
 
 	// because aur usually has a single repo for each package, but we have a single repo with individual
-	// branches for each package, we need to spoof this to show that the ref is refs/heads/master
-	masterRef := fmt.Sprintf("%s refs/heads/master\n", commitHash)
-	buf.WriteString(fmt.Sprintf("%04x%s", len(masterRef)+4, masterRef))
+	// branches for each package, we need to spoof this to show that the ref is refs/heads/master.
+	// the first ref (HEAD) carries the capabilities
+	for i, ref := range refs {
+		line := fmt.Sprintf("%s %s", ref.Hash, ref.Name)
+		if i == 0 {
+			line += "\x00" + strings.Join(capabilities, " ")
+		}
+		pw.WriteString(line + "\n")
+
+		// annotated tags are followed by the commit they point at
+		if ref.Peeled != "" {
+			pw.WriteString(fmt.Sprintf("%s %s^{}\n", ref.Peeled, ref.Name))
+		}
+	}
 
 	// claude says this is the flush packet
-	buf.WriteString("0000")
+	pw.Flush()
 
-	e.Response().Header().Set("Content-Type", "application/x-git-upload-pack-advertisement")
-	e.Response().Header().Set("Cache-Control", "no-cache")
 	return e.Blob(200, "application/x-git-upload-pack-advertisement", buf.Bytes())
 }
 
-func (s *Server) serveUploadPack(e echo.Context, packageName string) error {
+// advertiseV2 builds the protocol v2 capability advertisement. unlike v0 there's no service line or refs, the client
+// asks for the refs afterwards with ls-refs
+func advertiseV2(fetchFeatures []string) []byte {
+	var buf bytes.Buffer
+	pw := pktline.NewWriter(&buf)
+
+	fetch := "fetch"
+	if len(fetchFeatures) > 0 {
+		fetch += "=" + strings.Join(fetchFeatures, " ")
+	}
+
+	for _, line := range []string{"version 2", "agent=myaur", "ls-refs", fetch, "object-format=sha1"} {
+		pw.WriteString(line + "\n")
+	}
+	pw.Flush()
+
+	return buf.Bytes()
+}
+
+func (s *Server) serveUploadPack(e echo.Context, packageName, commitHash string) error {
 	logger := s.logger.With("route", "handleGit", "git-component", "serveUploadPack", "package-name", packageName)
 
-	bodyBytes, err := io.ReadAll(e.Request().Body)
+	// the request context gets cancelled when the client goes away, which stops upload-pack along with it
+	ctx, cancel := context.WithTimeout(e.Request().Context(), s.gitTimeout)
+	defer cancel()
+
+	// git compresses larger requests (i.e. ones with a lot of haves)
+	var body io.Reader = e.Request().Body
+	switch e.Request().Header.Get("Content-Encoding") {
+	case "gzip", "x-gzip":
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			logger.Error("failed to read gzipped upload-pack request", "err", err)
+			return e.String(400, "Failed to read request")
+		}
+		defer gz.Close()
+		body = gz
+	case "", "identity":
+	default:
+		return e.String(415, "Unsupported Content-Encoding")
+	}
+
+	e.Response().Header().Set("Content-Type", "application/x-git-upload-pack-result")
+	e.Response().Header().Set("Cache-Control", "no-cache")
+
+	if isProtocolV2(e.Request()) && s.repo.UploadPackV2Capabilities() != nil {
+		return s.serveCommandV2(ctx, e, logger, body, packageName, commitHash)
+	}
+
+	// because aur usually has a single repo for each package, but we have a single repo with individual
+	// branches for each package, we need to spoof this to show that the ref is refs/heads/master. the request is
+	// rewritten as it streams through, rather than reading the whole thing first, and wants for anything outside of
+	// the package never reach upload-pack
+	rewriter := s.newPackageRewriter(packageName, commitHash)
+	pr, pw := io.Pipe()
+	reqDone := make(chan error, 1)
+	go func() {
+		err := rewriter.RewriteRequest(pw, body)
+		pw.CloseWithError(err)
+		reqDone <- err
+	}()
+	defer pr.Close()
+
+	// the pack gets sent to the client as upload-pack writes it, so large packages are never held in memory and the
+	// client sees progress straight away
+	if err := s.repo.UploadPack(ctx, pr, &flushWriter{resp: e.Response()}); err != nil {
+		if rejected, err := requestRejected(e, pr, reqDone); rejected {
+			return err
+		}
+		return uploadPackFailed(e, logger, err)
+	}
+
+	return nil
+}
+
+// serveCommandV2 handles a single protocol v2 command. ls-refs gets answered from the package's refs, since the
+// mirror's own refs are every package in the aur, and fetch gets passed on to upload-pack
+func (s *Server) serveCommandV2(ctx context.Context, e echo.Context, logger *slog.Logger, body io.Reader, packageName, commitHash string) error {
+	pr := pktline.NewReader(body)
+
+	first, err := pr.ReadPacket()
 	if err != nil {
-		logger.Error("failed to read upload-pack request", "err", err)
+		logger.Error("failed to read protocol v2 command", "err", err)
 		return e.String(400, "Failed to read request")
 	}
 
-	// because aur usually has a single repo for each package, but we have a single repo with individual
-	// branches for each package, we need to spoof this to show that the ref is refs/heads/master
-	modifiedBody := bytes.ReplaceAll(bodyBytes, []byte("refs/heads/master"), fmt.Appendf(nil, "refs/heads/%s", packageName))
+	switch strings.TrimSuffix(string(first.Payload), "\n") {
+	case "command=ls-refs":
+		refs, err := s.packageRefs(packageName, commitHash)
+		if err != nil {
+			logger.Error("failed to list refs", "err", err)
+			return e.String(500, "Failed to list refs")
+		}
+		return s.serveLsRefs(e, pr, refs)
+	case "command=fetch":
+	default:
+		return e.String(400, "Unsupported command")
+	}
+
+	// put the command line back in front of the rest of the request, rewriting any want-refs and checking the wants
+	// as it goes through
+	rewriter := s.newPackageRewriter(packageName, commitHash)
+	reqR, reqW := io.Pipe()
+	reqDone := make(chan error, 1)
+	go func() {
+		err := pktline.NewWriter(reqW).WritePacket(first)
+		if err == nil {
+			err = rewriter.RewriteRequest(reqW, pr.Remaining())
+		}
+		reqW.CloseWithError(err)
+		reqDone <- err
+	}()
+	defer reqR.Close()
 
-	// then we can use upload-pack to serve the pack file
-	cmd := exec.Command("git", "upload-pack", "--stateless-rpc", s.repoPath)
-	cmd.Stdin = bytes.NewReader(modifiedBody)
+	// the response refers to the refs in the mirror in wanted-refs, which need to be rewritten back to the names
+	// the client asked for on the way out
+	respR, respW := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := s.repo.UploadPackV2(ctx, reqR, respW)
+		respW.CloseWithError(err)
+		done <- err
+	}()
 
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+	rewriteErr := rewriter.RewriteFetchResponse(&flushWriter{resp: e.Response()}, respR)
+	// if the client went away, stop upload-pack from trying to write anything else
+	respR.CloseWithError(rewriteErr)
 
-	if err := cmd.Run(); err != nil {
-		logger.Error("upload-pack failed", "err", err, "stderr", stderr.String(), "package", packageName)
-		return e.String(500, fmt.Sprintf("upload pack failed: %s", stderr.String()))
+	if err := <-done; err != nil {
+		if rejected, err := requestRejected(e, reqR, reqDone); rejected {
+			return err
+		}
+		return uploadPackFailed(e, logger, err)
+	}
+	if rewriteErr != nil {
+		logger.Error("failed to send fetch response", "err", rewriteErr)
 	}
 
-	e.Response().Header().Set("Content-Type", "application/x-git-upload-pack-result")
-	e.Response().Header().Set("Cache-Control", "no-cache")
-	return e.Blob(200, "application/x-git-upload-pack-result", stdout.Bytes())
+	return nil
+}
+
+// serveLsRefs answers a protocol v2 ls-refs command with the package's refs
+func (s *Server) serveLsRefs(e echo.Context, pr *pktline.Reader, refs []advertisedRef) error {
+	// the capabilities come first, then a delimiter, then the arguments
+	var prefixes []string
+	var symrefs, peel bool
+	for {
+		p, err := pr.ReadPacket()
+		if err != nil {
+			return e.String(400, "Failed to read request")
+		}
+		if p.Kind == pktline.Flush {
+			break
+		}
+
+		arg := strings.TrimSuffix(string(p.Payload), "\n")
+		switch {
+		case strings.HasPrefix(arg, "ref-prefix "):
+			prefixes = append(prefixes, strings.TrimPrefix(arg, "ref-prefix "))
+		case arg == "symrefs":
+			symrefs = true
+		case arg == "peel":
+			peel = true
+		}
+	}
+
+	var buf bytes.Buffer
+	pw := pktline.NewWriter(&buf)
+	for _, ref := range refs {
+		if len(prefixes) > 0 && !slices.ContainsFunc(prefixes, func(p string) bool { return strings.HasPrefix(ref.Name, p) }) {
+			continue
+		}
+
+		line := fmt.Sprintf("%s %s", ref.Hash, ref.Name)
+		if symrefs && ref.Target != "" {
+			line += " symref-target:" + ref.Target
+		}
+		if peel && ref.Peeled != "" {
+			line += " peeled:" + ref.Peeled
+		}
+		pw.WriteString(line + "\n")
+	}
+	pw.Flush()
+
+	return e.Blob(200, "application/x-git-upload-pack-result", buf.Bytes())
+}
+
+// requestRejected tells the client why its request was rejected, if the rewriter refused to pass it on to
+// upload-pack. a ref or object the package doesn't have never reaches upload-pack, so it's up to us to answer with
+// the error upload-pack would have given. reqR gets closed first, so the rewriter can't be stuck writing to it
+func requestRejected(e echo.Context, reqR *io.PipeReader, reqDone <-chan error) (bool, error) {
+	reqR.Close()
+	reqErr := <-reqDone
+
+	var unknownRef *pktline.UnknownRefError
+	var unknownObject *pktline.UnknownObjectError
+	if !errors.As(reqErr, &unknownRef) && !errors.As(reqErr, &unknownObject) {
+		return false, nil
+	}
+	// once any of the response has been sent, there's no way to get the error to the client
+	if e.Response().Committed {
+		return false, nil
+	}
+
+	var buf bytes.Buffer
+	pktline.NewWriter(&buf).WriteString(fmt.Sprintf("ERR %s\n", reqErr))
+	return true, e.Blob(200, "application/x-git-upload-pack-result", buf.Bytes())
+}
+
+// uploadPackFailed responds to an upload-pack that failed, if the response hasn't already been started
+func uploadPackFailed(e echo.Context, logger *slog.Logger, err error) error {
+	logger.Error("upload-pack failed", "err", err)
+
+	// once any of the response has been sent, the status can't be changed anymore
+	if e.Response().Committed {
+		return nil
+	}
+	return e.String(500, fmt.Sprintf("upload pack failed: %s", err))
+}
+
+// flushWriter flushes the response after every write, so that the client gets everything as soon as it's written
+type flushWriter struct {
+	resp *echo.Response
+}
+
+func (w *flushWriter) Write(p []byte) (int, error) {
+	n, err := w.resp.Write(p)
+	if err != nil {
+		return n, err
+	}
+	w.resp.Flush()
+	return n, nil
 }
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/haileyok/myaur/myaur/gitrepo"
+	"github.com/labstack/echo/v4"
+)
+
+// clients that can't speak the smart protocol (or are told not to with GIT_SMART_HTTP=0) read the repo's files
+// directly instead. none of those files exist for a package, since they'd be the mirror's, so they get built from the
+// package's refs
+
+// serveDumbHead serves the repo's HEAD file, which is always a symref to master
+func (s *Server) serveDumbHead(e echo.Context) error {
+	e.Response().Header().Set("Cache-Control", "no-cache")
+	return e.String(200, "ref: refs/heads/master\n")
+}
+
+// serveDumbInfoRefs serves the info/refs file that `git update-server-info` would write for the package's repo. it
+// lists every ref other than HEAD, and the commit each annotated tag points at
+func (s *Server) serveDumbInfoRefs(e echo.Context, packageName, commitHash string) error {
+	refs, err := s.packageRefs(packageName, commitHash)
+	if err != nil {
+		s.logger.Error("failed to list refs", "route", "handleGit", "git-component", "serveDumbInfoRefs", "package-name", packageName, "err", err)
+		return e.String(500, "Failed to list refs")
+	}
+
+	var buf bytes.Buffer
+	for _, ref := range refs {
+		if ref.Target != "" {
+			continue
+		}
+
+		fmt.Fprintf(&buf, "%s\t%s\n", ref.Hash, ref.Name)
+		if ref.Peeled != "" {
+			fmt.Fprintf(&buf, "%s\t%s^{}\n", ref.Peeled, ref.Name)
+		}
+	}
+
+	e.Response().Header().Set("Cache-Control", "no-cache")
+	return e.Blob(200, "text/plain; charset=utf-8", buf.Bytes())
+}
+
+// serveDumbPacks serves the list of packs in the repo. the mirror's packs hold every package in the aur, so none
+// are listed and clients fetch each object on its own instead
+func (s *Server) serveDumbPacks(e echo.Context) error {
+	e.Response().Header().Set("Cache-Control", "no-cache")
+	return e.Blob(200, "text/plain; charset=utf-8", nil)
+}
+
+var looseObjectRegex = regexp.MustCompile(`^objects/([0-9a-f]{2})/([0-9a-f]{38})$`)
+
+// looseObjectHash gets the object hash out of a loose object path, i.e. `objects/ab/cdef...`
+func looseObjectHash(gitPath string) (string, bool) {
+	m := looseObjectRegex.FindStringSubmatch(gitPath)
+	if m == nil {
+		return "", false
+	}
+	return m[1] + m[2], true
+}
+
+// serveDumbObject serves an object the same way it'd be stored as a loose object, which is a zlib compressed header
+// of its type and size followed by its contents. every package shares the mirror's objects, so only the ones
+// reachable from the package's refs are served, and anything else is a 404 like it would be in the package's own repo
+func (s *Server) serveDumbObject(e echo.Context, packageName, commitHash, hash string) error {
+	logger := s.logger.With("route", "handleGit", "git-component", "serveDumbObject", "package-name", packageName, "hash", hash)
+
+	reachable, err := s.packageReachable(packageName, commitHash, hash)
+	if err != nil {
+		logger.Error("failed to list reachable objects", "err", err)
+		return e.String(500, "Failed to read object")
+	}
+	if !reachable {
+		return e.String(404, "Not Found")
+	}
+
+	typ, data, err := s.repo.ReadObject(hash)
+	if errors.Is(err, gitrepo.ErrObjectNotFound) {
+		return e.String(404, "Not Found")
+	}
+	if err != nil {
+		logger.Error("failed to read object", "err", err)
+		return e.String(500, "Failed to read object")
+	}
+
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	fmt.Fprintf(zw, "%s %d\x00", typ, len(data))
+	zw.Write(data)
+	if err := zw.Close(); err != nil {
+		logger.Error("failed to compress object", "err", err)
+		return e.String(500, "Failed to read object")
+	}
+
+	// objects never change, so they can be cached forever
+	e.Response().Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	return e.Blob(200, "application/x-git-loose-object", buf.Bytes())
+}
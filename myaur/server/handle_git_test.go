@@ -0,0 +1,289 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/haileyok/myaur/myaur/gitrepo"
+	"github.com/haileyok/myaur/myaur/pktline"
+	"github.com/labstack/echo/v4"
+)
+
+// runGit runs git in dir, failing the test if it doesn't succeed, and returns its trimmed output
+func runGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_CONFIG_GLOBAL=/dev/null",
+		"GIT_CONFIG_NOSYSTEM=1",
+		"GIT_AUTHOR_NAME=alice",
+		"GIT_AUTHOR_EMAIL=alice@example.com",
+		"GIT_COMMITTER_NAME=alice",
+		"GIT_COMMITTER_EMAIL=alice@example.com",
+	)
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s failed: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// newGitMirror builds an aur style repo with a branch for each package and mirrors it, returning the path of the
+// mirror. yay has two commits and a tag, and the mirror's HEAD points at libfoo rather than any package being cloned
+func newGitMirror(t *testing.T) string {
+	t.Helper()
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+
+	runGit(t, dir, "init", "-q", src)
+
+	commit := func(branch, file, content string) {
+		if err := os.WriteFile(filepath.Join(src, file), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		runGit(t, src, "add", ".")
+		runGit(t, src, "commit", "-q", "-m", "update "+branch)
+	}
+
+	runGit(t, src, "checkout", "-q", "--orphan", "yay")
+	commit("yay", "PKGBUILD", "pkgname=yay\npkgver=1\n")
+	commit("yay", "PKGBUILD", "pkgname=yay\npkgver=2\n")
+	runGit(t, src, "tag", "-a", "v2", "-m", "v2")
+	commit("yay", "PKGBUILD", "pkgname=yay\npkgver=3\n")
+
+	runGit(t, src, "checkout", "-q", "--orphan", "libfoo")
+	runGit(t, src, "rm", "-rfq", ".")
+	commit("libfoo", "PKGBUILD", "pkgname=libfoo\n")
+
+	mirror := filepath.Join(dir, "mirror")
+	runGit(t, dir, "clone", "-q", "--mirror", src, mirror)
+
+	return mirror
+}
+
+// newGitServer serves the mirror with the given git backend
+func newGitServer(t *testing.T, mirror, backend string) *httptest.Server {
+	t.Helper()
+
+	repo, err := gitrepo.New(&gitrepo.Args{
+		RepoPath: mirror,
+		Backend:  backend,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{
+		logger:     slog.New(slog.NewJSONHandler(io.Discard, nil)),
+		echo:       echo.New(),
+		repo:       repo,
+		repoPath:   mirror,
+		gitTimeout: time.Minute,
+	}
+	s.addRoutes()
+
+	ts := httptest.NewServer(s.echo)
+	t.Cleanup(ts.Close)
+
+	return ts
+}
+
+func TestShallowCloneV2(t *testing.T) {
+	mirror := newGitMirror(t)
+	ts := newGitServer(t, mirror, gitrepo.BackendExec)
+
+	dir := t.TempDir()
+	runGit(t, dir, "-c", "protocol.version=2", "clone", "-q", "--depth", "1", ts.URL+"/yay.git", "yay")
+
+	clone := filepath.Join(dir, "yay")
+	if got, want := runGit(t, clone, "rev-parse", "HEAD"), runGit(t, mirror, "rev-parse", "refs/heads/yay"); got != want {
+		t.Errorf("HEAD is %s, want yay's commit %s", got, want)
+	}
+	if got := runGit(t, clone, "rev-list", "--count", "HEAD"); got != "1" {
+		t.Errorf("shallow clone has %s commits, want 1", got)
+	}
+	if got := runGit(t, clone, "rev-parse", "--is-shallow-repository"); got != "true" {
+		t.Errorf("clone is not shallow")
+	}
+}
+
+// --shallow-exclude sends the ref it was given, which has to be found in the package's refs rather than the mirror's
+func TestShallowExclude(t *testing.T) {
+	for _, version := range []string{"0", "2"} {
+		t.Run("v"+version, func(t *testing.T) {
+			mirror := newGitMirror(t)
+			ts := newGitServer(t, mirror, gitrepo.BackendExec)
+
+			dir := t.TempDir()
+			runGit(t, dir, "-c", "protocol.version="+version, "clone", "-q", "--shallow-exclude", "v2", ts.URL+"/yay.git", "yay")
+
+			// only the commit after the tag is left
+			clone := filepath.Join(dir, "yay")
+			if got := runGit(t, clone, "rev-list", "--count", "HEAD"); got != "1" {
+				t.Errorf("clone has %s commits, want 1", got)
+			}
+
+			// libfoo is a branch in the mirror, but the package doesn't have it
+			cmd := exec.Command("git", "-c", "protocol.version="+version, "clone", "-q", "--shallow-exclude", "libfoo", ts.URL+"/yay.git", "libfoo")
+			cmd.Dir = dir
+			if out, err := cmd.CombinedOutput(); err == nil {
+				t.Errorf("excluding libfoo succeeded, want an error: %s", out)
+			}
+		})
+	}
+}
+
+func TestDumbClone(t *testing.T) {
+	for _, backend := range []string{gitrepo.BackendExec, gitrepo.BackendGoGit} {
+		t.Run(backend, func(t *testing.T) {
+			mirror := newGitMirror(t)
+			ts := newGitServer(t, mirror, backend)
+
+			// make git read the repo's files rather than speaking the smart protocol
+			t.Setenv("GIT_SMART_HTTP", "0")
+
+			dir := t.TempDir()
+			runGit(t, dir, "clone", "-q", ts.URL+"/yay.git", "yay")
+
+			clone := filepath.Join(dir, "yay")
+			if got, want := runGit(t, clone, "rev-parse", "HEAD"), runGit(t, mirror, "rev-parse", "refs/heads/yay"); got != want {
+				t.Errorf("HEAD is %s, want yay's commit %s", got, want)
+			}
+			if got := runGit(t, clone, "tag"); got != "v2" {
+				t.Errorf("got tags %q, want v2", got)
+			}
+			runGit(t, clone, "fsck", "--strict")
+
+			// libfoo's objects are in the same mirror, but they aren't part of yay
+			tree := runGit(t, mirror, "rev-parse", "refs/heads/libfoo^{tree}")
+			for _, hash := range []string{runGit(t, mirror, "rev-parse", "refs/heads/libfoo"), tree} {
+				resp, err := http.Get(ts.URL + "/yay.git/objects/" + hash[:2] + "/" + hash[2:])
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusNotFound {
+					t.Errorf("got status %d for libfoo's object %s, want %d", resp.StatusCode, hash, http.StatusNotFound)
+				}
+			}
+		})
+	}
+}
+
+// postUploadPack sends a raw upload-pack request made of the given pkt-lines, where "0000" and "0001" are a flush and
+// a delimiter, and returns the response
+func postUploadPack(t *testing.T, url string, v2 bool, lines ...string) string {
+	t.Helper()
+
+	var body bytes.Buffer
+	pw := pktline.NewWriter(&body)
+	for _, line := range lines {
+		switch line {
+		case "0000":
+			pw.Flush()
+		case "0001":
+			pw.Delim()
+		default:
+			pw.WriteString(line)
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-git-upload-pack-request")
+	if v2 {
+		req.Header.Set("Git-Protocol", "version=2")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	out, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+// the smart protocol counterpart to the 404s in TestDumbClone. upload-pack runs on the whole mirror, so wanting
+// another package's objects by id has to be stopped before it gets there
+func TestSmartUnreachableWant(t *testing.T) {
+	tests := []struct {
+		backend string
+		v2      bool
+	}{
+		{backend: gitrepo.BackendExec},
+		{backend: gitrepo.BackendExec, v2: true},
+		{backend: gitrepo.BackendGoGit},
+	}
+
+	for _, tt := range tests {
+		name := tt.backend + " v0"
+		if tt.v2 {
+			name = tt.backend + " v2"
+		}
+
+		t.Run(name, func(t *testing.T) {
+			mirror := newGitMirror(t)
+			ts := newGitServer(t, mirror, tt.backend)
+			url := ts.URL + "/yay.git/git-upload-pack"
+
+			fetch := func(want string) string {
+				if tt.v2 {
+					return postUploadPack(t, url, true, "command=fetch\n", "0001", "no-progress\n", "want "+want+"\n", "done\n", "0000")
+				}
+				return postUploadPack(t, url, false, "want "+want+" ofs-delta\n", "0000", "done\n")
+			}
+
+			if got := fetch(runGit(t, mirror, "rev-parse", "refs/heads/yay~1")); !strings.Contains(got, "PACK") {
+				t.Errorf("wanting yay's own commit didn't return a pack: %q", got)
+			}
+
+			libfoo := runGit(t, mirror, "rev-parse", "refs/heads/libfoo")
+			got := fetch(libfoo)
+			if strings.Contains(got, "PACK") {
+				t.Errorf("wanting libfoo's commit under yay returned a pack")
+			}
+			if !strings.Contains(got, "ERR upload-pack: not our ref "+libfoo) {
+				t.Errorf("got %q, want a not our ref error", got)
+			}
+		})
+	}
+
+	// the same thing through git itself, which is happy to fetch an object by id over v2
+	t.Run("git fetch", func(t *testing.T) {
+		mirror := newGitMirror(t)
+		ts := newGitServer(t, mirror, gitrepo.BackendExec)
+
+		dir := t.TempDir()
+		runGit(t, dir, "init", "-q")
+		cmd := exec.Command("git", "-c", "protocol.version=2", "fetch", "-q", ts.URL+"/yay.git", runGit(t, mirror, "rev-parse", "refs/heads/libfoo"))
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "GIT_CONFIG_GLOBAL=/dev/null", "GIT_CONFIG_NOSYSTEM=1")
+		if out, err := cmd.CombinedOutput(); err == nil {
+			t.Errorf("fetching libfoo's commit under yay succeeded\n%s", out)
+		}
+	})
+}
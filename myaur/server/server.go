@@ -14,6 +14,7 @@ import (
 	"github.com/haileyok/myaur/myaur/database"
 	"github.com/haileyok/myaur/myaur/gitrepo"
 	"github.com/haileyok/myaur/myaur/populate"
+	"github.com/haileyok/myaur/myaur/resolve"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/labstack/gommon/log"
@@ -26,18 +27,31 @@ type Server struct {
 	httpd          *http.Server
 	db             *database.Database
 	populator      *populate.Populate
+	repo           gitrepo.Repo
+	resolver       *resolve.Resolver
+	maxResults     int
+	gitTimeout     time.Duration
 	remoteRepoUrl  string
 	repoPath       string
 	autoUpdate     bool
 	updateInterval time.Duration
+	// the objects reachable from each package, for dumb http clients
+	reachable reachableCache
 }
 
 type Args struct {
-	Addr           string
-	DatabasePath   string
-	RemoteRepoUrl  string
-	RepoPath       string
-	Concurrency    int
+	Addr          string
+	DatabasePath  string
+	RemoteRepoUrl string
+	RepoPath      string
+	GitBackend    string
+	Concurrency   int
+	// path to a file mapping commit author emails to aur usernames, passed along to populate
+	MaintainerOverrides string
+	// the most packages a single search may return. larger searches get a `Too many package results.` error
+	MaxResults int
+	// how long a single clone or fetch may take before it gets cancelled
+	GitTimeout     time.Duration
 	AutoUpdate     bool
 	UpdateInterval time.Duration
 	Debug          bool
@@ -53,6 +67,15 @@ func New(args *Args) (*Server, error) {
 		args.RemoteRepoUrl = gitrepo.DefaultAurRepoUrl
 	}
 
+	if args.GitTimeout == 0 {
+		args.GitTimeout = 10 * time.Minute
+	}
+
+	if args.MaxResults == 0 {
+		// same as the aur's default max_rpc_results
+		args.MaxResults = 5000
+	}
+
 	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
 		Level: level,
 	}))
@@ -75,19 +98,38 @@ func New(args *Args) (*Server, error) {
 		return nil, fmt.Errorf("failed to create new database client: %w", err)
 	}
 
+	repo, err := gitrepo.New(&gitrepo.Args{
+		RepoPath:   args.RepoPath,
+		AurRepoUrl: args.RemoteRepoUrl,
+		Backend:    args.GitBackend,
+		Debug:      args.Debug,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create repo client: %w", err)
+	}
+
 	populator, err := populate.New(&populate.Args{
-		DatabasePath:  args.DatabasePath,
-		RepoPath:      args.RepoPath,
-		RemoteRepoUrl: args.RemoteRepoUrl,
-		Debug:         args.Debug,
-		Concurrency:   args.Concurrency,
+		DatabasePath:        args.DatabasePath,
+		RepoPath:            args.RepoPath,
+		RemoteRepoUrl:       args.RemoteRepoUrl,
+		GitBackend:          args.GitBackend,
+		Debug:               args.Debug,
+		Concurrency:         args.Concurrency,
+		MaintainerOverrides: args.MaintainerOverrides,
 	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create populate client: %w", err)
+	}
 
 	s := Server{
 		echo:           e,
 		httpd:          &httpd,
 		db:             db,
 		populator:      populator,
+		repo:           repo,
+		resolver:       resolve.New(db),
+		maxResults:     args.MaxResults,
+		gitTimeout:     args.GitTimeout,
 		logger:         logger,
 		remoteRepoUrl:  args.RemoteRepoUrl,
 		repoPath:       args.RepoPath,
@@ -226,9 +268,18 @@ func (s *Server) Serve(ctx context.Context) error {
 }
 
 func (s *Server) addRoutes() {
-	s.echo.GET("/rpc", s.handleRpc)
-	s.echo.GET("/rpc/v5/info", s.handleGetInfo)
-	s.echo.GET("/rpc/v5/search/:term", s.handleGetSearch)
+	// the aur accepts POST for all of these too, with the parameters in a form encoded body
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		s.echo.Add(method, "/rpc", s.handleRpc)
+		s.echo.Add(method, "/rpc/v5/info", s.handleGetInfo)
+		s.echo.Add(method, "/rpc/v5/search/:term", s.handleGetSearch)
+		s.echo.Add(method, "/rpc/v5/suggest/:term", s.handleGetSuggest)
+		s.echo.Add(method, "/rpc/v5/suggest-pkgbase/:term", s.handleGetSuggestPkgbase)
+	}
+
+	s.echo.GET("/api/search/:term", s.handleGetApiSearch)
+	s.echo.GET("/api/resolve", s.handleGetResolve)
+	s.echo.GET("/api/reverse-deps/:name", s.handleGetReverseDeps)
 
 	s.echo.GET("/", func(e echo.Context) error {
 		return e.String(200, "an AUR mirror. code at https://github.com/haileyok/myaur")
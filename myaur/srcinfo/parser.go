@@ -3,13 +3,63 @@ package srcinfo
 import (
 	"bufio"
 	"fmt"
+	"slices"
 	"strings"
 
 	"github.com/haileyok/myaur/myaur/database"
+	"github.com/haileyok/myaur/myaur/vercmp"
 )
 
-func Parse(content string) (*database.PackageInfo, error) {
-	pkg := &database.PackageInfo{}
+// fields that may be given an architecture suffix in a .SRCINFO, i.e. `depends_x86_64` or `sha256sums_aarch64`
+var archFields = map[string]struct{}{
+	"source":       {},
+	"depends":      {},
+	"makedepends":  {},
+	"checkdepends": {},
+	"optdepends":   {},
+	"provides":     {},
+	"conflicts":    {},
+	"replaces":     {},
+	"md5sums":      {},
+	"sha1sums":     {},
+	"sha224sums":   {},
+	"sha256sums":   {},
+	"sha384sums":   {},
+	"sha512sums":   {},
+	"b2sums":       {},
+	"cksums":       {},
+}
+
+// splitArchKey splits a key like `depends_x86_64` into its field and architecture. ok is false if the key is not
+// an architecture specific key
+func splitArchKey(key string) (field, arch string, ok bool) {
+	field, arch, found := strings.Cut(key, "_")
+	if !found || arch == "" {
+		return "", "", false
+	}
+	if _, ok := archFields[field]; !ok {
+		return "", "", false
+	}
+	return field, arch, true
+}
+
+// Parse parses the contents of a .SRCINFO file. a single .SRCINFO may declare multiple packages (split packages),
+// so every package in the file is returned. each package starts out with the pkgbase-level fields, and any field
+// that is set inside of a `pkgname` section replaces the pkgbase value for that package only.
+func Parse(content string) ([]*database.PackageInfo, error) {
+	base := &database.PackageInfo{}
+	var pkgs []*database.PackageInfo
+
+	// the package that is currently being parsed. before we see the first `pkgname` this is the pkgbase section
+	cur := base
+
+	// keys that have already been set inside of the current pkgname section. the first time a list key shows up
+	// in a package section it replaces the inherited value, rather than appending to it
+	overridden := map[string]bool{}
+
+	// these can only be set on the pkgbase, and are joined into the full version once everything is parsed
+	var pkgver, pkgrel string
+
 	scanner := bufio.NewScanner(strings.NewReader(content))
 
 	// each looks like `key = val`. most of the lines will have whitespace infront of
@@ -34,27 +84,68 @@ func Parse(content string) (*database.PackageInfo, error) {
 		key := strings.TrimSpace(pts[0])
 		value := strings.TrimSpace(pts[1])
 
+		// a new pkgname starts a new package section, which inherits everything from the pkgbase
+		if key == "pkgname" {
+			cur = clonePackage(base)
+			cur.Name = value
+			pkgs = append(pkgs, cur)
+			overridden = map[string]bool{}
+			continue
+		}
+
+		// values inside of a package section override the pkgbase value instead of adding to it
+		if cur != base && !overridden[key] {
+			overridden[key] = true
+			resetField(cur, key)
+		}
+
+		// an empty value is used in package sections to clear an inherited value, so there's nothing to add
+		if value == "" {
+			continue
+		}
+
+		// architecture specific fields get stored separately so they can be merged in when a client asks for an arch
+		if field, arch, ok := splitArchKey(key); ok {
+			if cur.ArchSpecific == nil {
+				cur.ArchSpecific = database.ArchSlices{}
+			}
+			cur.ArchSpecific.Add(field, arch, value)
+			continue
+		}
+
 		switch key {
-		case "pkgname":
-			pkg.Name = value
 		case "pkgbase":
-			pkg.PackageBase = value
+			cur.PackageBase = value
 		case "pkgver":
-			pkg.Version = value
+			pkgver = value
 		case "pkgrel":
-			if pkg.Version != "" {
-				pkg.Version = pkg.Version + "-" + value
-			}
+			pkgrel = value
+		case "epoch":
+			cur.Epoch = value
 		case "pkgdesc":
-			pkg.Description = value
+			cur.Description = value
 		case "url":
-			pkg.Url = value
+			cur.Url = value
 		case "depends":
-			pkg.Depends = append(pkg.Depends, value)
+			cur.Depends = append(cur.Depends, value)
 		case "makedepends":
-			pkg.MakeDepends = append(pkg.MakeDepends, value)
+			cur.MakeDepends = append(cur.MakeDepends, value)
+		case "checkdepends":
+			cur.CheckDepends = append(cur.CheckDepends, value)
+		case "optdepends":
+			cur.OptDepends = append(cur.OptDepends, value)
+		case "provides":
+			cur.Provides = append(cur.Provides, value)
+		case "conflicts":
+			cur.Conflicts = append(cur.Conflicts, value)
+		case "replaces":
+			cur.Replaces = append(cur.Replaces, value)
+		case "groups":
+			cur.Groups = append(cur.Groups, value)
+		case "backup":
+			cur.Backup = append(cur.Backup, value)
 		case "license":
-			pkg.License = append(pkg.License, value)
+			cur.License = append(cur.License, value)
 		}
 	}
 
@@ -62,9 +153,76 @@ func Parse(content string) (*database.PackageInfo, error) {
 		return nil, fmt.Errorf("error scanning srcinfo: %w", err)
 	}
 
-	if pkg.Name == "" {
+	if len(pkgs) == 0 {
 		return nil, fmt.Errorf("missing required field: pkgname")
 	}
 
-	return pkg, nil
+	// the epoch comes after pkgver and pkgrel in the file, so the full version can only be built at the end
+	for _, pkg := range pkgs {
+		pkg.Version = vercmp.Join(pkg.Epoch, pkgver, pkgrel)
+	}
+
+	return pkgs, nil
+}
+
+// resetField clears the field for the given key, so that a package section can replace the inherited value
+func resetField(pkg *database.PackageInfo, key string) {
+	if field, arch, ok := splitArchKey(key); ok {
+		delete(pkg.ArchSpecific[field], arch)
+		return
+	}
+
+	switch key {
+	case "pkgdesc":
+		pkg.Description = ""
+	case "url":
+		pkg.Url = ""
+	case "depends":
+		pkg.Depends = nil
+	case "makedepends":
+		pkg.MakeDepends = nil
+	case "checkdepends":
+		pkg.CheckDepends = nil
+	case "optdepends":
+		pkg.OptDepends = nil
+	case "provides":
+		pkg.Provides = nil
+	case "conflicts":
+		pkg.Conflicts = nil
+	case "replaces":
+		pkg.Replaces = nil
+	case "groups":
+		pkg.Groups = nil
+	case "backup":
+		pkg.Backup = nil
+	case "license":
+		pkg.License = nil
+	}
+}
+
+// clonePackage copies the package, including any of the list fields, so that appending to the clone never touches
+// the original
+func clonePackage(pkg *database.PackageInfo) *database.PackageInfo {
+	c := *pkg
+	c.Depends = slices.Clone(pkg.Depends)
+	c.MakeDepends = slices.Clone(pkg.MakeDepends)
+	c.CheckDepends = slices.Clone(pkg.CheckDepends)
+	c.OptDepends = slices.Clone(pkg.OptDepends)
+	c.Provides = slices.Clone(pkg.Provides)
+	c.Conflicts = slices.Clone(pkg.Conflicts)
+	c.Replaces = slices.Clone(pkg.Replaces)
+	c.Groups = slices.Clone(pkg.Groups)
+	c.Backup = slices.Clone(pkg.Backup)
+	c.License = slices.Clone(pkg.License)
+	c.Keywords = slices.Clone(pkg.Keywords)
+
+	c.ArchSpecific = database.ArchSlices{}
+	for field, arches := range pkg.ArchSpecific {
+		c.ArchSpecific[field] = map[string][]string{}
+		for arch, values := range arches {
+			c.ArchSpecific[field][arch] = slices.Clone(values)
+		}
+	}
+
+	return &c
 }
@@ -0,0 +1,81 @@
+package srcinfo
+
+import (
+	"strings"
+
+	"github.com/haileyok/myaur/myaur/database"
+)
+
+// ParseRelation splits a relation like `foo>=1.2` into its name, operator and version. optdepends entries may have a
+// description after the name (`foo: for bar support`), which is dropped. if there is no version constraint, the
+// operator and version are empty.
+func ParseRelation(value string) (name, operator, version string) {
+	// optdepends descriptions come after a colon. versions can contain a colon too (for the epoch), but the
+	// description is always separated by a colon and a space
+	if before, _, found := strings.Cut(value, ": "); found {
+		value = before
+	}
+	value = strings.TrimSpace(value)
+
+	idx := strings.IndexAny(value, "<>=")
+	if idx == -1 {
+		return value, "", ""
+	}
+
+	name = value[:idx]
+	rest := value[idx:]
+
+	for _, op := range []string{">=", "<=", "=", "<", ">"} {
+		if after, ok := strings.CutPrefix(rest, op); ok {
+			return name, op, after
+		}
+	}
+
+	return name, "", ""
+}
+
+// Relations builds the normalized relations for a package out of all of its relation lists, including the
+// architecture specific ones
+func Relations(pkg *database.PackageInfo) []database.PackageRelation {
+	var relations []database.PackageRelation
+
+	add := func(kind, arch string, values []string) {
+		for _, v := range values {
+			name, op, ver := ParseRelation(v)
+			if name == "" {
+				continue
+			}
+			relations = append(relations, database.PackageRelation{
+				Kind:     kind,
+				Name:     name,
+				Operator: op,
+				Version:  ver,
+				Arch:     arch,
+			})
+		}
+	}
+
+	add(database.RelationDepends, "", pkg.Depends)
+	add(database.RelationMakeDepends, "", pkg.MakeDepends)
+	add(database.RelationCheckDepends, "", pkg.CheckDepends)
+	add(database.RelationOptDepends, "", pkg.OptDepends)
+	add(database.RelationProvides, "", pkg.Provides)
+	add(database.RelationConflicts, "", pkg.Conflicts)
+	add(database.RelationReplaces, "", pkg.Replaces)
+
+	for kind, arches := range pkg.ArchSpecific {
+		// source and checksums end up in here too, but they aren't relations
+		switch kind {
+		case database.RelationDepends, database.RelationMakeDepends, database.RelationCheckDepends, database.RelationOptDepends,
+			database.RelationProvides, database.RelationConflicts, database.RelationReplaces:
+		default:
+			continue
+		}
+
+		for arch, values := range arches {
+			add(kind, arch, values)
+		}
+	}
+
+	return relations
+}
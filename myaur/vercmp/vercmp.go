@@ -0,0 +1,200 @@
+// Package vercmp implements pacman's version comparison, matching the behaviour of libalpm's `alpm_pkg_vercmp`
+// (and the `vercmp` command line tool). versions look like `[epoch:]pkgver[-pkgrel]`.
+package vercmp
+
+import "strings"
+
+// Compare compares two full versions. it returns -1 if a is older than b, 0 if they are the same, and 1 if a is
+// newer than b. the pkgrel is only compared if both versions have one, so `1.0` is equal to `1.0-2`.
+func Compare(a, b string) int {
+	if a == b {
+		return 0
+	}
+
+	epochA, verA, relA := parseEVR(a)
+	epochB, verB, relB := parseEVR(b)
+
+	ret := rpmvercmp(epochA, epochB)
+	if ret == 0 {
+		ret = rpmvercmp(verA, verB)
+		if ret == 0 && relA != "" && relB != "" {
+			ret = rpmvercmp(relA, relB)
+		}
+	}
+
+	return ret
+}
+
+// Satisfies reports whether version satisfies the constraint given by operator and constraint, i.e. whether
+// `1.2-1` satisfies `>= 1.0`. an empty operator is satisfied by any version.
+func Satisfies(version, operator, constraint string) bool {
+	if operator == "" {
+		return true
+	}
+
+	cmp := Compare(version, constraint)
+	switch operator {
+	case "=":
+		return cmp == 0
+	case ">=":
+		return cmp >= 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case "<":
+		return cmp < 0
+	default:
+		return false
+	}
+}
+
+// Join builds a full version out of its parts, the same way makepkg does. an epoch of zero is left off.
+func Join(epoch, pkgver, pkgrel string) string {
+	if pkgver == "" {
+		return ""
+	}
+
+	version := pkgver
+	if pkgrel != "" {
+		version = version + "-" + pkgrel
+	}
+	if epoch != "" && epoch != "0" {
+		version = epoch + ":" + version
+	}
+
+	return version
+}
+
+// parseEVR splits a version into its epoch, version and release. the epoch defaults to `0`, and the release is
+// empty if the version does not have one
+func parseEVR(evr string) (epoch, version, release string) {
+	// the epoch is only the leading digits before a colon
+	i := 0
+	for i < len(evr) && isDigit(evr[i]) {
+		i++
+	}
+
+	if i < len(evr) && evr[i] == ':' {
+		epoch = evr[:i]
+		version = evr[i+1:]
+		if epoch == "" {
+			epoch = "0"
+		}
+	} else {
+		epoch = "0"
+		version = evr
+	}
+
+	if idx := strings.LastIndexByte(version, '-'); idx != -1 {
+		release = version[idx+1:]
+		version = version[:idx]
+	}
+
+	return epoch, version, release
+}
+
+// rpmvercmp compares two version segments. this is a straight port of rpmvercmp from libalpm, which splits each
+// version into runs of digits and runs of letters, comparing numeric runs numerically and alpha runs lexically.
+// numeric runs are always newer than alpha runs, and the separators between runs only matter by their length.
+func rpmvercmp(a, b string) int {
+	if a == b {
+		return 0
+	}
+
+	one, two := 0, 0
+	for one < len(a) && two < len(b) {
+		prevOne, prevTwo := one, two
+
+		// skip over any separators
+		for one < len(a) && !isAlnum(a[one]) {
+			one++
+		}
+		for two < len(b) && !isAlnum(b[two]) {
+			two++
+		}
+
+		// if we ran off the end of either, we're done
+		if one >= len(a) || two >= len(b) {
+			break
+		}
+
+		// if the separator lengths were different, we're also done
+		if one-prevOne != two-prevTwo {
+			if one-prevOne < two-prevTwo {
+				return -1
+			}
+			return 1
+		}
+
+		// grab the next run of digits or letters from each, based on what the first one starts with
+		endOne, endTwo := one, two
+		isNum := isDigit(a[one])
+		if isNum {
+			for endOne < len(a) && isDigit(a[endOne]) {
+				endOne++
+			}
+			for endTwo < len(b) && isDigit(b[endTwo]) {
+				endTwo++
+			}
+		} else {
+			for endOne < len(a) && isAlpha(a[endOne]) {
+				endOne++
+			}
+			for endTwo < len(b) && isAlpha(b[endTwo]) {
+				endTwo++
+			}
+		}
+
+		// if the second run is empty, the two were different types. numeric runs are newer than alpha ones
+		if endTwo == two {
+			if isNum {
+				return 1
+			}
+			return -1
+		}
+
+		segOne, segTwo := a[one:endOne], b[two:endTwo]
+
+		if isNum {
+			// leading zeros don't count, and after that whichever number is longer is bigger
+			segOne = strings.TrimLeft(segOne, "0")
+			segTwo = strings.TrimLeft(segTwo, "0")
+			if len(segOne) > len(segTwo) {
+				return 1
+			}
+			if len(segTwo) > len(segOne) {
+				return -1
+			}
+		}
+
+		if c := strings.Compare(segOne, segTwo); c != 0 {
+			return c
+		}
+
+		one, two = endOne, endTwo
+	}
+
+	if one >= len(a) && two >= len(b) {
+		return 0
+	}
+
+	// whatever is left over decides it. a remaining alpha run should never beat running out, so `1.0a` is older than
+	// `1.0`, but `1.0.1` is newer than `1.0`
+	if (one >= len(a) && !isAlpha(b[two])) || (one < len(a) && isAlpha(a[one])) {
+		return -1
+	}
+	return 1
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isAlpha(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isAlnum(c byte) bool {
+	return isDigit(c) || isAlpha(c)
+}
@@ -0,0 +1,155 @@
+package vercmp
+
+import "testing"
+
+// these are the vectors from pacman's test/util/vercmptest.sh. each one is also checked the other way around
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		// all similar length, no pkgrel
+		{"1.5.0", "1.5.0", 0},
+		{"1.5.1", "1.5.0", 1},
+
+		// mixed length
+		{"1.5.1", "1.5", 1},
+
+		// with pkgrel, simple
+		{"1.5.0-1", "1.5.0-1", 0},
+		{"1.5.0-1", "1.5.0-2", -1},
+		{"1.5.0-1", "1.5.1-1", -1},
+		{"1.5.0-2", "1.5.1-1", -1},
+
+		// with pkgrel, mixed lengths
+		{"1.5-1", "1.5.1-1", -1},
+		{"1.5-2", "1.5.1-1", -1},
+		{"1.5-2", "1.5.1-2", -1},
+
+		// the pkgrel only counts when both sides have one
+		{"1.5", "1.5-1", 0},
+		{"1.5-1", "1.5", 0},
+		{"1.1-1", "1.1", 0},
+		{"1.0-1", "1.1", -1},
+		{"1.1-1", "1.0", 1},
+
+		// alphanumeric versions
+		{"1.5b-1", "1.5-1", -1},
+		{"1.5b", "1.5", -1},
+		{"1.5b-1", "1.5", -1},
+		{"1.5b", "1.5.1", -1},
+
+		// from the manpage
+		{"1.0a", "1.0alpha", -1},
+		{"1.0alpha", "1.0b", -1},
+		{"1.0b", "1.0beta", -1},
+		{"1.0beta", "1.0rc", -1},
+		{"1.0rc", "1.0", -1},
+
+		// alpha-dotted versions
+		{"1.5.a", "1.5", 1},
+		{"1.5.b", "1.5.a", 1},
+		{"1.5.1", "1.5.b", 1},
+
+		// alpha dots and dashes
+		{"1.5.b-1", "1.5.b", 0},
+		{"1.5-1", "1.5.b", -1},
+
+		// same or similar content with different separators
+		{"2.0", "2_0", 0},
+		{"2.0_a", "2_0.a", 0},
+		{"2.0a", "2.0.a", -1},
+		{"2___a", "2_a", 1},
+
+		// epochs
+		{"0:1.0", "0:1.0", 0},
+		{"0:1.0", "0:1.1", -1},
+		{"1:1.0", "0:1.0", 1},
+		{"1:1.0", "0:1.1", 1},
+		{"1:1.0", "2:1.1", -1},
+
+		// epochs with a pkgrel on only some of them
+		{"1:1.0", "0:1.0-1", 1},
+		{"1:1.0-1", "0:1.1-1", 1},
+
+		// an epoch on only one side, where a missing epoch is 0
+		{"0:1.0", "1.0", 0},
+		{"0:1.0", "1.1", -1},
+		{"0:1.1", "1.0", 1},
+		{"1:1.0", "1.0", 1},
+		{"1:1.0", "1.1", 1},
+		{"1:1.1", "1.1", 1},
+	}
+
+	for _, tt := range tests {
+		if got := Compare(tt.a, tt.b); got != tt.want {
+			t.Errorf("Compare(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := Compare(tt.b, tt.a); got != -tt.want {
+			t.Errorf("Compare(%q, %q) = %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
+
+// an alpha run is older than running out, but a separator followed by anything is newer
+func TestCompareOrder(t *testing.T) {
+	versions := []string{"1.0a", "1.0", "1.0.a", "1.0.1"}
+
+	for i := range versions {
+		for j := range versions {
+			want := 0
+			if i < j {
+				want = -1
+			} else if i > j {
+				want = 1
+			}
+			if got := Compare(versions[i], versions[j]); got != want {
+				t.Errorf("Compare(%q, %q) = %d, want %d", versions[i], versions[j], got, want)
+			}
+		}
+	}
+}
+
+func TestSatisfies(t *testing.T) {
+	tests := []struct {
+		version    string
+		operator   string
+		constraint string
+		want       bool
+	}{
+		{"1.0-1", "", "", true},
+		{"1.0-1", "", "2.0", true},
+
+		{"1.0-1", "=", "1.0", true},
+		{"1.0-1", "=", "1.0-1", true},
+		{"1.0-2", "=", "1.0-1", false},
+		{"1.0-1", "=", "1:1.0", false},
+
+		{"1.0-1", ">=", "1.0", true},
+		{"1.1-1", ">=", "1.0", true},
+		{"1.0b-1", ">=", "1.0", false},
+
+		{"1.0-1", "<=", "1.0", true},
+		{"1.0rc-1", "<=", "1.0", true},
+		{"1.0.1-1", "<=", "1.0", false},
+
+		{"1.1-1", ">", "1.0", true},
+		{"1.0-2", ">", "1.0", false},
+		{"1.0-2", ">", "1.0-1", true},
+		{"1:0.1-1", ">", "9.9", true},
+
+		{"1.0-1", "<", "1.1", true},
+		{"1.0-1", "<", "1.0", false},
+		{"1.0-1", "<", "1.0-2", true},
+
+		// anything else isn't an operator
+		{"1.0-1", "==", "1.0", false},
+		{"1.0-1", "!=", "2.0", false},
+	}
+
+	for _, tt := range tests {
+		if got := Satisfies(tt.version, tt.operator, tt.constraint); got != tt.want {
+			t.Errorf("Satisfies(%q, %q, %q) = %v, want %v", tt.version, tt.operator, tt.constraint, got, tt.want)
+		}
+	}
+}